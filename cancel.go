@@ -0,0 +1,127 @@
+package bpnet
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// FlowStatus is the lifecycle state of a flow
+type FlowStatus string
+
+const (
+	StatusRunning   FlowStatus = "running"
+	StatusCompleted FlowStatus = "completed"
+	StatusCancelled FlowStatus = "cancelled"
+)
+
+// errors of starts, fires and cancels of a flow which has ended
+var (
+	ErrFlowCancelled = errors.New("flow is cancelled")
+	ErrFlowCompleted = errors.New("flow is completed")
+)
+
+// a cancelled flow does not start or fire anymore
+func (f *Flow) checkActive() error {
+	if f.Status == StatusCancelled {
+		return ErrFlowCancelled
+	}
+	return nil
+}
+
+// Cancel terminates a running flow, see CancelContext
+func (f *Flow) Cancel(reason string) error {
+	return f.CancelContext(context.Background(), reason)
+}
+
+// CancelContext terminates a running flow. Its timers are stopped, OnSystemTaskCancelled is
+// called for every system task in progress and OnProcessCancelled for the flow. The running
+// subflows are loaded via FlowInstanceLoader and cancelled too. Later fires return ErrFlowCancelled.
+// The first hook or loader error is returned, the flow is cancelled anyway.
+func (f *Flow) CancelContext(ctx context.Context, reason string) error {
+	children, err := f.cancel(ctx, reason)
+	if errors.Is(err, ErrFlowCancelled) || errors.Is(err, ErrFlowCompleted) {
+		return err
+	}
+	// die kinder erst nach dem unlock abbrechen, ein subflow kann gerade seinen parent feuern
+	e := f.Engine()
+	for _, childID := range children {
+		child, loadErr := e.loadFlow(ctx, childID)
+		if loadErr != nil {
+			err = firstError(err, HookError{Hook: HookFlowInstanceLoader, Transition: -1, Err: loadErr})
+			continue
+		}
+		if child == nil {
+			continue
+		}
+		childErr := child.CancelContext(ctx, reason)
+		if errors.Is(childErr, ErrFlowCancelled) || errors.Is(childErr, ErrFlowCompleted) {
+			continue
+		}
+		err = firstError(err, childErr)
+	}
+	return err
+}
+
+// cancels the flow itself and returns its running subflows
+func (f *Flow) cancel(ctx context.Context, reason string) (children []ulid.ULID, err error) {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	switch f.Status {
+	case StatusCancelled:
+		return nil, ErrFlowCancelled
+	case StatusCompleted:
+		return nil, ErrFlowCompleted
+	}
+	e := f.Engine()
+	f.Status = StatusCancelled
+	f.CancelReason = reason
+
+	for tokenID := range f.TimersDue {
+		err = firstError(err, e.disarmTimer(f, tokenID))
+	}
+	tokens := make([]int, 0, len(f.TransitionsInProgress))
+	for tokenID := range f.TransitionsInProgress {
+		tokens = append(tokens, tokenID)
+	}
+	sort.Ints(tokens)
+	for _, tokenID := range tokens {
+		transition := f.TransitionsInProgress[tokenID]
+		if f.Process.TransitionTypes[transition] == int(SYSTEM) {
+			err = firstError(err, e.systemTaskCancelled(ctx, f, tokenID, transition))
+		}
+	}
+	f.TransitionsInProgress = make(map[int]int)
+	f.TimersDue = make(map[int]time.Time)
+	f.Assignees = nil
+	f.Incidents = nil
+	f.Attempts = nil
+	for tokenID := range f.Subscriptions {
+		f.unsubscribe(tokenID)
+	}
+	e.dropHeldMessages(f.ID)
+	f.AvailableUserTransitions = nil
+	children = f.RunningSubProcesses
+	f.RunningSubProcesses = nil
+
+	e.Detach(f.ID)
+	f.releaseSingleton()
+	f.record(Event{Type: EventCancelled, Actor: actorID(ctx, f.Owner), Reason: reason})
+	err = firstError(err, e.notify(ctx, HookProcessCancelled, f, -1))
+	return children, err
+}
+
+// stops an armed timer and removes it from the TimerStore
+func (e *Engine) disarmTimer(f *Flow, tokenID int) error {
+	key := timerKey{f.ID, tokenID}
+	e.mu.Lock()
+	if timer, ok := e.armed[key]; ok {
+		timer.Stop()
+		delete(e.armed, key)
+	}
+	e.mu.Unlock()
+	return e.timerStore().Cancel(f.ID, tokenID)
+}
@@ -0,0 +1,125 @@
+package bpnet_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/veith/bpnet"
+)
+
+func TestCancel_StopsTimers(t *testing.T) {
+	clock := newManualClock()
+	var cancelled int
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnProcessCancelled: func(flow *bpnet.Flow, transitionIndex int) bool {
+			cancelled++
+			return true
+		},
+	})
+	e.Clock = clock
+
+	f := e.CreateFlow(timedProcess(60), "veith")
+	f.Start(nil)
+	if err := f.Cancel("customer withdrew"); err != nil {
+		t.Fatal(err)
+	}
+	if cancelled != 1 {
+		t.Error("OnProcessCancelled should be called once, was called", cancelled)
+	}
+	if f.Status != bpnet.StatusCancelled || f.CancelReason != "customer withdrew" {
+		t.Error("flow should be cancelled with its reason, is", f.Status, f.CancelReason)
+	}
+	if timers, _ := e.Timers.List(); len(timers) != 0 {
+		t.Error("timers of a cancelled flow should be removed from the store", timers)
+	}
+
+	clock.Advance(time.Hour)
+	if f.Net.State[2] != 0 {
+		t.Error("timer of a cancelled flow should not fire", f.Net.State)
+	}
+	if err := f.Fire(2, nil); !errors.Is(err, bpnet.ErrFlowCancelled) {
+		t.Error("fire of a cancelled flow should fail, got", err)
+	}
+	if err := f.Cancel("again"); !errors.Is(err, bpnet.ErrFlowCancelled) {
+		t.Error("second cancel should fail, got", err)
+	}
+	if len(e.Flows()) != 0 {
+		t.Error("cancelled flow should be removed from the engine")
+	}
+}
+
+func TestCancel_CascadesToSubflows(t *testing.T) {
+	var cancelledFlows, cancelledTasks []string
+	e := bpnet.NewEngine(nil)
+	e.Hooks = &bpnet.HandlerV2{
+		ProcessDefinitionLoader: func(ctx context.Context, processName string) (*bpnet.Process, error) {
+			p := hookProcess(t, "system")
+			p.Name = "child"
+			return &p, nil
+		},
+		OnSystemTask: func(ctx context.Context, flow *bpnet.Flow, tokenID int, transitionIndex int) error {
+			return nil
+		},
+		OnSystemTaskCancelled: func(ctx context.Context, flow *bpnet.Flow, tokenID int, transitionIndex int) error {
+			cancelledTasks = append(cancelledTasks, flow.ProcessName)
+			return nil
+		},
+		OnProcessCancelled: func(ctx context.Context, flow *bpnet.Flow, transitionIndex int) error {
+			cancelledFlows = append(cancelledFlows, flow.ProcessName)
+			return nil
+		},
+	}
+
+	process := buildfile(t, "test/hooks.yaml", func(net *bpnet.ImportNet) {
+		net.Transition[1].TransitionType = "subprocess"
+		net.Transition[1].Details = map[string]interface{}{"process": "child"}
+	})
+	f := e.CreateFlow(process, "veith")
+	if err := f.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.RunningSubProcesses) != 1 {
+		t.Fatal("subflow should run, have", f.RunningSubProcesses)
+	}
+	child, _ := e.Flow(f.RunningSubProcesses[0])
+
+	if err := f.Cancel("stop"); err != nil {
+		t.Fatal(err)
+	}
+	if child.Status != bpnet.StatusCancelled {
+		t.Error("subflow should be cancelled, is", child.Status)
+	}
+	if len(cancelledFlows) != 2 || cancelledFlows[0] != "hooks" || cancelledFlows[1] != "child" {
+		t.Error("OnProcessCancelled should be called for the flow and its subflow, got", cancelledFlows)
+	}
+	if len(cancelledTasks) != 1 || cancelledTasks[0] != "child" {
+		t.Error("OnSystemTaskCancelled should be called for the open system task, got", cancelledTasks)
+	}
+	if err := child.FireSystemTask(2, nil); !errors.Is(err, bpnet.ErrFlowCancelled) {
+		t.Error("system task of a cancelled subflow should not complete, got", err)
+	}
+}
+
+func TestCancel_ClosesTasks(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.History = sink
+	f := e.CreateFlow(buildfile(t, "test/worklist.yaml"), "veith")
+	f.Start(nil)
+	if err := f.Cancel("withdrawn"); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := f.Tasks(); len(tasks) != 0 {
+		t.Error("cancelled flow should have no tasks", tasks)
+	}
+	if err := f.Claim(2, anna); !errors.Is(err, bpnet.ErrFlowCancelled) {
+		t.Error("claim on a cancelled flow should fail, got", err)
+	}
+	for _, event := range sink.Events(f.ID) {
+		if event.Type == bpnet.EventTaskClaimed {
+			t.Error("claim should not be recorded", event)
+		}
+	}
+}
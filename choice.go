@@ -0,0 +1,137 @@
+package bpnet
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/oklog/ulid"
+)
+
+// choice policies of a place whose token is taken by one of several AUTO or MESSAGE transitions
+const (
+	ChoiceFirst  = "first"  // the branch with the best priority, equal priorities by transition index (default)
+	ChoiceStrict = "strict" // two branches with the same priority are a ChoiceError
+)
+
+// ChoiceError is reported when several branches of a strict choice qualify, none of them fires
+type ChoiceError struct {
+	FlowID      ulid.ULID
+	Place       string
+	Transitions []string
+}
+
+func (e ChoiceError) Error() string {
+	return fmt.Sprintf("flow %s: branches %s of place %s qualify at the same time", e.FlowID, strings.Join(e.Transitions, ", "), e.Place)
+}
+
+// the process declares priorities, default arcs or choice policies
+func (p Process) hasChoices() bool {
+	return p.PriorityMatrix != nil || p.DefaultMatrix != nil || p.ChoicePolicies != nil
+}
+
+func (p Process) choicePolicy(place int) string {
+	if place < len(p.ChoicePolicies) && p.ChoicePolicies[place] != "" {
+		return p.ChoicePolicies[place]
+	}
+	return ChoiceFirst
+}
+
+func (p Process) defaultArc(transition int, place int) bool {
+	return transition < len(p.DefaultMatrix) && place < len(p.DefaultMatrix[transition]) && p.DefaultMatrix[transition][place]
+}
+
+// priority 1 is taken before 2, arcs without priority come last
+func (p Process) arcPriority(transition int, place int) int {
+	if transition < len(p.PriorityMatrix) && place < len(p.PriorityMatrix[transition]) && p.PriorityMatrix[transition][place] > 0 {
+		return p.PriorityMatrix[transition][place]
+	}
+	return math.MaxInt32
+}
+
+// best priority of the input arcs of a transition
+func (p Process) transitionPriority(transition int) int {
+	best := math.MaxInt32
+	for place, weight := range p.InputMatrix[transition] {
+		if priority := p.arcPriority(transition, place); weight > 0 && priority < best {
+			best = priority
+		}
+	}
+	return best
+}
+
+// the enabled AUTO and MESSAGE transitions in the order they fire. Where several branches
+// compete for the token of a place, a default arc only fires when no other branch qualifies.
+// Under a strict policy equal priorities block all branches and return a ChoiceError.
+func (f *Flow) autofireOrder(blocked map[int]bool) ([]int, error) {
+	var candidates []int
+	for _, transition := range f.Net.EnabledTransitions {
+		if blocked[transition] {
+			continue
+		}
+		if f.Process.TransitionTypes[transition] == int(AUTO) || f.Process.TransitionTypes[transition] == int(MESSAGE) {
+			candidates = append(candidates, transition)
+		}
+	}
+	if !f.Process.hasChoices() {
+		return candidates, nil
+	}
+
+	var err error
+	for place := range f.Net.State {
+		var branches, defaults []int
+		for _, transition := range candidates {
+			if blocked[transition] || f.Process.InputMatrix[transition][place] == 0 {
+				continue
+			}
+			if f.Process.defaultArc(transition, place) {
+				defaults = append(defaults, transition)
+			} else {
+				branches = append(branches, transition)
+			}
+		}
+		if len(branches)+len(defaults) < 2 {
+			continue
+		}
+		// der default nur, wenn kein anderer zweig feuern kann
+		if len(branches) > 0 {
+			for _, transition := range defaults {
+				blocked[transition] = true
+			}
+		}
+		if f.Process.choicePolicy(place) != ChoiceStrict || len(branches) < 2 {
+			continue
+		}
+		sort.SliceStable(branches, func(i, j int) bool {
+			return f.Process.arcPriority(branches[i], place) < f.Process.arcPriority(branches[j], place)
+		})
+		if f.Process.arcPriority(branches[0], place) == f.Process.arcPriority(branches[1], place) {
+			choiceErr := ChoiceError{FlowID: f.ID, Place: f.placeID(place)}
+			for _, transition := range branches {
+				blocked[transition] = true
+				choiceErr.Transitions = append(choiceErr.Transitions, f.transitionID(transition))
+			}
+			err = firstError(err, choiceErr)
+		}
+	}
+
+	order := candidates[:0:0]
+	for _, transition := range candidates {
+		if !blocked[transition] {
+			order = append(order, transition)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return f.Process.transitionPriority(order[i]) < f.Process.transitionPriority(order[j])
+	})
+	return order, err
+}
+
+// id of a place, the index for processes without place ids
+func (f *Flow) placeID(place int) string {
+	if place < len(f.Process.Places) {
+		return f.Process.Places[place]
+	}
+	return fmt.Sprint(place)
+}
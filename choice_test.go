@@ -0,0 +1,63 @@
+package bpnet_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// test/choice-*.yaml: start -> big (auto) | small (auto) | fallback (auto) -> done places, unused branches wait on idle
+
+func TestChoice_Default(t *testing.T) {
+	process := buildfile(t, "test/choice-default.yaml")
+	for amount, place := range map[int]int{5: 3, 500: 1} {
+		f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(process, "veith")
+		if err := f.Start(map[string]interface{}{"amount": amount}); err != nil {
+			t.Fatal(err)
+		}
+		if f.Net.State[place] != 1 || f.Net.State[0] != 0 {
+			t.Error("amount", amount, "should take the branch to place", place, "state", f.Net.State)
+		}
+	}
+}
+
+func TestChoice_Priority(t *testing.T) {
+	process := buildfile(t, "test/choice-priority.yaml")
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(process, "veith")
+	if err := f.Start(map[string]interface{}{"amount": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[2] != 1 {
+		t.Error("branch with priority 1 should fire, state", f.Net.State)
+	}
+}
+
+func TestChoice_StrictConflict(t *testing.T) {
+	process := buildfile(t, "test/choice-strict.yaml")
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(process, "veith")
+	err := f.Start(map[string]interface{}{"amount": 1})
+	var choiceErr bpnet.ChoiceError
+	if !errors.As(err, &choiceErr) || choiceErr.Place != "start" || len(choiceErr.Transitions) != 2 {
+		t.Fatal("two qualifying branches should be a ChoiceError, got", err)
+	}
+	if f.Net.State[0] != 1 {
+		t.Error("no branch should fire, state", f.Net.State)
+	}
+}
+
+func TestValidateImportNet_Choice(t *testing.T) {
+	net := bpnet.ImportNet{
+		Title:      "choice",
+		Place:      []bpnet.Place{{ID: "start", Choice: "random"}, {ID: "end"}},
+		Transition: []bpnet.Transition{{ID: "a", TransitionType: "auto"}},
+		Arc: []bpnet.Arc{
+			{Source: "start", Destination: "a", Type: "pt", Default: true, Condition: "true"},
+			{Source: "a", Destination: "end", Type: "tp", Priority: 1},
+		},
+	}
+	report := bpnet.ValidateImportNet(net)
+	if report.Len() != 3 {
+		t.Error("should report the policy, the default condition and the tp priority, got", report.Issues)
+	}
+}
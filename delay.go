@@ -0,0 +1,148 @@
+package bpnet
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antonmedv/expr"
+)
+
+// delay of a TIMED transition with an unset, empty or zero delay
+const defaultDelay = 100 * time.Millisecond
+
+// P1Y2M3W4DT5H6M7.5S, every part is optional
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+
+// dueTime calculates when a timer with the given delay from Details["delay"] expires.
+//
+// Supported delays:
+//
+//	12.5                      seconds (numbers)
+//	"PT1H30M", "P1D"          ISO-8601 durations
+//	"90m", "1h30m"            Go durations
+//	"2023-03-15T10:00:00Z"    absolute RFC3339 timestamps
+//	"eod", "eow", "eom"       end of day, week (monday 00:00) and month
+//	"= reminder * 60"         expressions over the flow variables, the result is parsed like a delay
+//
+// Negative durations are rejected. An unset, empty or zero delay falls back to 100ms.
+func dueTime(delay interface{}, now time.Time, variables map[string]interface{}) (time.Time, error) {
+	switch d := delay.(type) {
+	case nil:
+		return now.Add(defaultDelay), nil
+	case float64:
+		return secondsFrom(now, d)
+	case float32:
+		return secondsFrom(now, float64(d))
+	case int:
+		return secondsFrom(now, float64(d))
+	case int64:
+		return secondsFrom(now, float64(d))
+	case time.Duration:
+		return durationFrom(now, d)
+	case time.Time:
+		return d, nil
+	case string:
+		s := strings.TrimSpace(d)
+		if strings.HasPrefix(s, "=") {
+			result, err := expr.Eval(strings.TrimPrefix(s, "="), variables)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("delay expression %q: %v", s, err)
+			}
+			if r, ok := result.(string); ok && strings.HasPrefix(strings.TrimSpace(r), "=") {
+				return time.Time{}, fmt.Errorf("delay expression %q returns an expression", s)
+			}
+			return dueTime(result, now, variables)
+		}
+		return parseDelayString(s, now)
+	}
+	return time.Time{}, fmt.Errorf("delay of type %T is not supported", delay)
+}
+
+func parseDelayString(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return now.Add(defaultDelay), nil
+	}
+	switch strings.ToLower(s) {
+	case "eod":
+		y, m, d := now.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()), nil
+	case "eow":
+		y, m, d := now.Date()
+		// tage bis zum nächsten montag
+		days := (8 - int(now.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return time.Date(y, m, d+days, 0, 0, 0, 0, now.Location()), nil
+	case "eom":
+		y, m, _ := now.Date()
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location()), nil
+	}
+	if strings.HasPrefix(s, "P") {
+		return parseISODuration(s, now)
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return durationFrom(now, d)
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return secondsFrom(now, seconds)
+	}
+	return time.Time{}, fmt.Errorf("delay %q is not a duration, timestamp or anchor", s)
+}
+
+func parseISODuration(s string, now time.Time) (time.Time, error) {
+	m := isoDuration.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return time.Time{}, fmt.Errorf("delay %q is not an ISO-8601 duration", s)
+	}
+	part := func(i int) int {
+		n, _ := strconv.Atoi(m[i])
+		return n
+	}
+	due := now.AddDate(part(1), part(2), part(3)*7+part(4))
+	due = due.Add(time.Duration(part(5))*time.Hour + time.Duration(part(6))*time.Minute)
+	if m[7] != "" {
+		seconds, _ := strconv.ParseFloat(strings.Replace(m[7], ",", ".", 1), 64)
+		due = due.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	if due.Equal(now) {
+		return now.Add(defaultDelay), nil
+	}
+	return due, nil
+}
+
+func secondsFrom(now time.Time, seconds float64) (time.Time, error) {
+	return durationFrom(now, time.Duration(seconds*float64(time.Second)))
+}
+
+// ein timer in der vergangenheit ist ein fehler in der definition,
+// zero is treated like an unset delay
+func durationFrom(now time.Time, d time.Duration) (time.Time, error) {
+	if d < 0 {
+		return time.Time{}, fmt.Errorf("delay %v is negative", d)
+	}
+	if d == 0 {
+		return now.Add(defaultDelay), nil
+	}
+	return now.Add(d), nil
+}
+
+// checks a delay when the process is built, expressions are only compiled.
+// Negative delays are rejected, timestamps may lie in the past.
+func validateDelay(delay interface{}) error {
+	if s, ok := delay.(string); ok && strings.HasPrefix(strings.TrimSpace(s), "=") {
+		_, err := expr.Compile(strings.TrimPrefix(strings.TrimSpace(s), "="))
+		if err != nil {
+			return fmt.Errorf("delay expression %q: %v", s, err)
+		}
+		return nil
+	}
+	_, err := dueTime(delay, time.Now(), nil)
+	return err
+}
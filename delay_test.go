@@ -0,0 +1,114 @@
+package bpnet_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/veith/bpnet"
+)
+
+func TestDelay_Formats(t *testing.T) {
+	// manual clock starts on wednesday, 2023-03-15 10:00 UTC
+	start := newManualClock().Now()
+	tests := []struct {
+		delay interface{}
+		due   time.Time
+	}{
+		{30, start.Add(30 * time.Second)},
+		{2.5, start.Add(2500 * time.Millisecond)},
+		{0, start.Add(100 * time.Millisecond)},
+		{"PT0S", start.Add(100 * time.Millisecond)},
+		{"", start.Add(100 * time.Millisecond)},
+		{"PT1H30M", start.Add(90 * time.Minute)},
+		{"P1DT12H", start.Add(36 * time.Hour)},
+		{"P1M", time.Date(2023, 4, 15, 10, 0, 0, 0, time.UTC)},
+		{"90m", start.Add(90 * time.Minute)},
+		{"2023-03-20T08:00:00Z", time.Date(2023, 3, 20, 8, 0, 0, 0, time.UTC)},
+		{"eod", time.Date(2023, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"eow", time.Date(2023, 3, 20, 0, 0, 0, 0, time.UTC)},
+		{"eom", time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"= remind * 60", start.Add(5 * time.Minute)},
+		{"= remindAt", time.Date(2023, 3, 17, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		e := bpnet.NewEngine(&bpnet.Handler{})
+		e.Clock = newManualClock()
+		f := e.CreateFlow(timedProcess(test.delay), "veith")
+		f.Process.Variables = []bpnet.Variable{{ID: "remind"}, {ID: "remindAt"}}
+		f.Start(map[string]interface{}{"remind": 5, "remindAt": "2023-03-17T09:00:00Z"})
+
+		timers, _ := e.Timers.List()
+		if len(timers) != 1 {
+			t.Error("delay", test.delay, "should arm a timer")
+			continue
+		}
+		if !timers[0].Due.Equal(test.due) {
+			t.Error("delay", test.delay, "should be due at", test.due, "is", timers[0].Due)
+		}
+	}
+}
+
+func TestDelay_InvalidAtRuntime(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.Clock = newManualClock()
+	f := e.CreateFlow(timedProcess("= missing + 1"), "veith")
+	f.Start(nil)
+
+	if timers, _ := e.Timers.List(); len(timers) != 0 {
+		t.Error("a delay which can not be evaluated should not arm a timer", timers)
+	}
+	if len(f.TransitionsInProgress) != 0 {
+		t.Error("token should stay in front of the timer", f.TransitionsInProgress)
+	}
+}
+
+func TestBuildProcessFromYaml_RejectsDelay(t *testing.T) {
+	for delay, valid := range map[string]bool{
+		"PT1H30M":     true,
+		"90m":         true,
+		"eom":         true,
+		"= wait * 60": true,
+		"PT":          false,
+		"1 hour":      false,
+		"= wait *":    false,
+		"2023-13-01":  false,
+		"-5":          false,
+		"-90m":        false,
+		"0":           true,
+		"PT0S":        true,
+		"":            true,
+	} {
+		net := []byte(`
+title: timed
+transitions:
+  - id: wait
+    type: timed
+    details:
+      delay: "` + delay + `"
+places:
+  - id: start
+    tokens: 1
+  - id: end
+arcs:
+  - sourceId: start
+    destinationId: wait
+    type: pt
+  - sourceId: wait
+    destinationId: end
+    type: tp
+`)
+		var yamlstruct bpnet.ImportNet
+		if err := yaml.Unmarshal(net, &yamlstruct); err != nil {
+			t.Fatal(err)
+		}
+		_, err := bpnet.BuildProcessFromYaml(yamlstruct)
+		if valid && err != nil {
+			t.Error("delay", delay, "should be valid, got", err)
+		}
+		if !valid && err == nil {
+			t.Error("delay", delay, "should be rejected")
+		}
+	}
+}
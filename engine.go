@@ -0,0 +1,169 @@
+package bpnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// Engine owns a set of hooks, loaders and the flows driven by them.
+// Run one engine per tenant to keep hooks and flows apart.
+type Engine struct {
+	Handler *Handler   // hooks and loaders of this engine
+	Hooks   *HandlerV2 // hooks with context and error, replace the same hooks of Handler
+	Clock   Clock      // time source for timers
+	Timers  TimerStore // due times of the armed timers
+
+	Singletons RunningFlowIndex // running flows per SingletonIdentifiers
+	History    EventSink        // history of the flows, nil records nothing
+	Policy     Policy           // authorization of starts and fires, nil for RolePolicy
+
+	Messages      MessageIndex // tokens waiting in RECEIVE transitions
+	MessageBuffer BufferPolicy // messages which arrive before their flow waits, see Correlate
+
+	OnHistoryError func(event Event, err error)             // gets the events the History refused, they are missing in the history of the flow
+	OnTimerError   func(flow *Flow, tokenID int, err error) // gets the errors of timers fired by the Clock, nobody else would see them
+
+	mu       sync.Mutex
+	flows    map[ulid.ULID]*Flow // started flows, until they complete
+	armed    map[timerKey]Timer  // timers armed on the clock
+	buffered []bufferedMessage   // messages nobody waited for, oldest first
+}
+
+// the engine behind RegisterHandler and Process.CreateFlow
+var defaultEngine = NewEngine(nil)
+
+// Creates an engine with its own handler
+func NewEngine(handler *Handler) *Engine {
+	return &Engine{
+		Handler: handler,
+		Clock:   realClock{},
+		Timers:  NewMemoryTimerStore(),
+
+		Singletons: NewMemoryFlowIndex(),
+		Messages:   NewMemoryMessageIndex(),
+		flows:      make(map[ulid.ULID]*Flow),
+		armed:      make(map[timerKey]Timer),
+	}
+}
+
+// DefaultEngine returns the engine used by RegisterHandler and Process.CreateFlow
+func DefaultEngine() *Engine {
+	return defaultEngine
+}
+
+// Creates a flow (process instance) from a process, bound to this engine
+func (e *Engine) CreateFlow(p Process, owner string) Flow {
+	flow := Flow{Owner: owner, Process: p, ID: makeUlid(), engine: e, mu: new(sync.Mutex)}
+	flow.ProcessName = p.Name
+	flow.ProcessVersion = p.Version
+	flow.Net.InputMatrix = p.InputMatrix
+	flow.Net.OutputMatrix = p.OutputMatrix
+	flow.Net.State = make([]int, len(p.InitialState))
+	copy(flow.Net.State, p.InitialState)
+	flow.Net.ConditionMatrix = make([][]string, len(p.ConditionMatrix))
+	copy(flow.Net.ConditionMatrix, p.ConditionMatrix)
+
+	flow.TransitionsInProgress = make(map[int]int)
+	flow.TimersDue = make(map[int]time.Time)
+	return flow
+}
+
+// Attach binds a flow, e.g. one loaded from storage, to this engine.
+// Its tokens waiting for messages are registered in the MessageIndex again.
+func (e *Engine) Attach(flow *Flow) {
+	flow.engine = e
+	e.track(flow)
+	for _, sub := range flow.Subscriptions {
+		e.messageIndex().Subscribe(sub)
+	}
+}
+
+// Detach removes a flow from the engine, the flow itself is not touched
+func (e *Engine) Detach(flowID ulid.ULID) {
+	e.mu.Lock()
+	delete(e.flows, flowID)
+	e.mu.Unlock()
+}
+
+// Flow returns a flow which is running on this engine
+func (e *Engine) Flow(flowID ulid.ULID) (*Flow, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	flow, ok := e.flows[flowID]
+	return flow, ok
+}
+
+// Flows lists all flows which are running on this engine
+func (e *Engine) Flows() []*Flow {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	flows := make([]*Flow, 0, len(e.flows))
+	for _, flow := range e.flows {
+		flows = append(flows, flow)
+	}
+	return flows
+}
+
+func (e *Engine) track(flow *Flow) {
+	e.mu.Lock()
+	if e.flows == nil {
+		e.flows = make(map[ulid.ULID]*Flow)
+	}
+	e.flows[flow.ID] = flow
+	e.mu.Unlock()
+}
+
+// returns the handler of the engine, never nil
+func (e *Engine) handler() *Handler {
+	if e.Handler != nil {
+		return e.Handler
+	}
+	if e == defaultEngine && BPNet != nil {
+		return BPNet
+	}
+	return &Handler{}
+}
+
+// loads a flow from the engine or via FlowInstanceLoader. The loader has to return
+// restored flows (RestoreFlow, LoadSnapshot), a flow read with json.Unmarshal has no net.
+func (e *Engine) loadFlow(ctx context.Context, flowID ulid.ULID) (*Flow, error) {
+	if flow, ok := e.Flow(flowID); ok {
+		return flow, nil
+	}
+	var flow *Flow
+	var err error
+	if loader := e.hooks().FlowInstanceLoader; loader != nil {
+		flow, err = loader(ctx, flowID)
+	} else if h := e.handler(); h.FlowInstanceLoader != nil {
+		flow, err = h.FlowInstanceLoader(flowID)
+	} else {
+		return nil, errors.New("no FlowInstanceLoader registered")
+	}
+	if err != nil || flow == nil {
+		return flow, err
+	}
+	if len(flow.Net.InputMatrix) == 0 || len(flow.Net.State) != len(flow.Net.InputMatrix[0]) {
+		return nil, fmt.Errorf("flow %s has no net, restore it with RestoreFlow or LoadSnapshot", flowID)
+	}
+	if flow.engine == nil {
+		flow.engine = e
+	}
+	return flow, nil
+}
+
+// loads a process definition via ProcessDefinitionLoader
+func (e *Engine) loadProcess(ctx context.Context, processName string) (*Process, error) {
+	if loader := e.hooks().ProcessDefinitionLoader; loader != nil {
+		return loader(ctx, processName)
+	}
+	h := e.handler()
+	if h.ProcessDefinitionLoader == nil {
+		return nil, errors.New("no ProcessDefinitionLoader registered")
+	}
+	return h.ProcessDefinitionLoader(processName)
+}
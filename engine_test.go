@@ -0,0 +1,83 @@
+package bpnet_test
+
+import (
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+func TestEngine_SeparateHandlers(t *testing.T) {
+	var startedA, startedB int
+	a := bpnet.NewEngine(&bpnet.Handler{OnProcessStarted: func(flow *bpnet.Flow, transitionIndex int) bool {
+		startedA++
+		return true
+	}})
+	b := bpnet.NewEngine(&bpnet.Handler{OnProcessStarted: func(flow *bpnet.Flow, transitionIndex int) bool {
+		startedB++
+		return true
+	}})
+
+	process := freshProcess()
+	fa := a.CreateFlow(process, "veith")
+	fa.Start(nil)
+	fb := b.CreateFlow(process, "veith")
+	fb.Start(nil)
+	fb2 := b.CreateFlow(process, "veith")
+	fb2.Start(nil)
+
+	if startedA != 1 || startedB != 2 {
+		t.Error("hooks should be called per engine, got", startedA, startedB)
+	}
+	if fa.Engine() != a || fb.Engine() != b {
+		t.Error("flows should be bound to their engine")
+	}
+	if _, ok := a.Flow(fb.ID); ok {
+		t.Error("engine a should not know flows of engine b")
+	}
+	if len(b.Flows()) != 2 {
+		t.Error("engine b should run 2 flows, has", len(b.Flows()))
+	}
+}
+
+func TestEngine_Subflow(t *testing.T) {
+	var loaded int
+	e := bpnet.NewEngine(&bpnet.Handler{
+		ProcessDefinitionLoader: func(processName string) (*bpnet.Process, error) {
+			loaded++
+			p := freshSubProcess()
+			return &p, nil
+		},
+		OnSendMessage: func(flow *bpnet.Flow, transitionIndex int) bool {
+			return true
+		},
+	})
+
+	process := freshProcess()
+	process.InitialState = []int{1, 0, 0, 0, 0, 0, 0, 0, 0}
+	process.TransitionTypes = []int{1, 5, 1, 1, 1, 1, 1}
+	process.Transitions = make([]bpnet.Transition, 7)
+	process.Transitions[1].Details = map[string]interface{}{"process": "sub"}
+
+	f := e.CreateFlow(process, "veith")
+	f.Start(nil)
+
+	if loaded != 1 {
+		t.Error("subprocess should be loaded via the engine, loaded", loaded)
+	}
+	if f.Net.State[len(f.Net.State)-1] != 1 {
+		t.Error("flow should complete with the subflow, state", f.Net.State)
+	}
+	if len(e.Flows()) != 0 {
+		t.Error("completed flows should be removed from the engine, have", len(e.Flows()))
+	}
+}
+
+func TestRegisterHandler_DefaultEngine(t *testing.T) {
+	if bpnet.DefaultEngine().Handler != &handler {
+		t.Error("RegisterHandler should set the handler of the default engine")
+	}
+	f := freshProcess().CreateFlow("veith")
+	if f.Engine() != bpnet.DefaultEngine() {
+		t.Error("Process.CreateFlow should use the default engine")
+	}
+}
@@ -1,54 +1,92 @@
 package bpnet
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/oklog/ulid"
 	"github.com/veith/petrinet"
 	"math/rand"
+	"sync"
 	"time"
 )
 
+// registers the handler of the default engine
 func RegisterHandler(handler *Handler) {
 	BPNet = handler
+	defaultEngine.Handler = handler
 }
 
-// Creates a flow (process instance) from a process
+// Creates a flow (process instance) from a process on the default engine
 func (p Process) CreateFlow(Owner string) Flow {
-	flow := Flow{Owner: Owner, Process: p, ID: makeUlid()}
-	flow.ProcessName = p.Name
-	flow.Net.InputMatrix = p.InputMatrix
-	flow.Net.OutputMatrix = p.OutputMatrix
-	flow.Net.State = make([]int, len(p.InitialState))
-	copy(flow.Net.State, p.InitialState)
-	flow.Net.ConditionMatrix = make([][]string, len(p.ConditionMatrix))
-	copy(flow.Net.ConditionMatrix, p.ConditionMatrix)
+	return defaultEngine.CreateFlow(p, Owner)
+}
 
-	flow.TransitionsInProgress = make(map[int]int)
-	return flow
+// returns the engine the flow is bound to
+func (flow *Flow) Engine() *Engine {
+	if flow.engine == nil {
+		return defaultEngine
+	}
+	return flow.engine
 }
 
-// read flow data
+// read flow data, the map is a copy which timers of the flow do not change
 func (flow *Flow) ReadData() map[string]interface{} {
-	return flow.Net.Variables
+	mu := flow.mutex()
+	mu.Lock()
+	defer mu.Unlock()
+	data := make(map[string]interface{}, len(flow.Net.Variables))
+	for name, value := range flow.Net.Variables {
+		data[name] = value
+	}
+	return data
 }
 
 // starts the flow with initial data
 func (flow *Flow) Start(data map[string]interface{}) error {
+	return flow.StartContext(context.Background(), data)
+}
+
+// StartContext starts the flow, ctx is passed to the hooks and the Policy.
+// A HookError is returned after the flow was started, the flow keeps running.
+func (flow *Flow) StartContext(ctx context.Context, data map[string]interface{}) error {
+	ctx, unlock := flow.lock(ctx)
+	defer unlock()
+	if err := flow.checkActive(); err != nil {
+		return err
+	}
+	if err := flow.authorize(ctx, ActionStart, -1); err != nil {
+		return err
+	}
+	return flow.start(ctx, data)
+}
+
+// starts without authorization, subflows are started by the engine
+func (flow *Flow) start(ctx context.Context, data map[string]interface{}) error {
+	ctx, unlock := flow.lock(ctx)
+	defer unlock()
 	//init
-	if BPNet.OnSubProcessStarted != nil && flow.ParentTransitionTokenID != 0 {
-		BPNet.OnSubProcessStarted(flow, flow.ParentTransitionTokenID)
+	e := flow.Engine()
+	var hookErr error
+	if e.hasHook(HookSubProcessStarted) && flow.ParentTransitionTokenID != 0 {
+		hookErr = e.notify(ctx, HookSubProcessStarted, flow, flow.ParentTransitionTokenID)
 	} else {
-		if BPNet.OnProcessStarted != nil {
-			BPNet.OnProcessStarted(flow, 0)
-		}
+		hookErr = e.notify(ctx, HookProcessStarted, flow, -1)
 	}
 	flow.Net.Variables = make(map[string]interface{})
 
-	err := flow.appendData(data, "___start")
-	if err.Len() == 0 {
-		flow.Net.Init()
-		flow.AvailableUserTransitions = flow.bpnTransitionsCheck()
-		return nil
+	changes, err := flow.appendData(data, "___start")
+	if err == nil {
+		err = flow.claimSingleton()
+	}
+	if err == nil {
+		flow.Status = StatusRunning
+		e.track(flow)
+		flow.netInit()
+		_, produced := tokenDiff(nil, flow.Net.TokenIds)
+		flow.record(Event{Type: EventStarted, Actor: flow.Owner, Changes: changes, Produced: produced})
+		flow.AvailableUserTransitions, err = flow.bpnTransitionsCheck(ctx)
+		return firstError(hookErr, err)
 	}
 	return err
 }
@@ -63,13 +101,18 @@ func (e *RequiredError) Len() int {
 }
 
 // // prüfe ob alle in der Transition VERLANGTEN Daten gesendet wurden
-func (flow *Flow) appendData(data map[string]interface{}, transitionID string) RequiredError {
+func (flow *Flow) appendData(data map[string]interface{}, transitionID string) ([]VariableChange, error) {
+
+	// typen prüfen, bei fehlern werden keine daten übernommen
+	coerced, err := flow.coerceData(data)
+	if err != nil {
+		return nil, err
+	}
 
 	// filter undefined fields
-	for _, v := range flow.Process.Variables {
-		if val, ok := data[v.ID]; ok {
-			flow.Net.Variables[v.ID] = val
-		}
+	changes := variableChanges(flow.Net.Variables, coerced)
+	for id, val := range coerced {
+		flow.Net.Variables[id] = val
 	}
 
 	// check for required fields
@@ -96,24 +139,53 @@ func (flow *Flow) appendData(data map[string]interface{}, transitionID string) R
 			}
 		}
 	}
-	return requiredError
+	if requiredError.Len() > 0 {
+		return changes, requiredError
+	}
+	return changes, nil
 }
 
 // Fire a transition / task
 func (f *Flow) Fire(transitionIndex int, data map[string]interface{}) error {
-	var err RequiredError
+	return f.FireContext(context.Background(), transitionIndex, data)
+}
+
+// FireContext fires a transition, ctx is passed to the hooks.
+// A HookError is returned after the transition has fired.
+func (f *Flow) FireContext(ctx context.Context, transitionIndex int, data map[string]interface{}) error {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	if err := f.checkActive(); err != nil {
+		return err
+	}
+	if f.Suspended {
+		return ErrFlowSuspended
+	}
+	if err := f.authorize(ctx, ActionFire, transitionIndex); err != nil {
+		return err
+	}
+	if err := f.checkAssignee(ctx, transitionIndex); err != nil {
+		return err
+	}
+	var err error
+	var changes []VariableChange
+	event := Event{Type: EventFired, Actor: actorID(ctx, f.Owner)}
 	if len(f.Process.Transitions) > transitionIndex {
-		err = f.appendData(data, f.Process.Transitions[transitionIndex].ID)
+		event.TransitionID = f.Process.Transitions[transitionIndex].ID
+		changes, err = f.appendData(data, event.TransitionID)
 	}
 
-	if err.Len() == 0 {
-		err := f.fire(transitionIndex)
-		if err == nil {
-			//f.AvailableUserTransitions = f.bpnTransitionsCheck();
-			if BPNet.OnFireCompleted != nil {
-				BPNet.OnFireCompleted(f, transitionIndex)
-			}
-			return nil
+	if err == nil {
+		event.Changes = changes
+		fired, err := f.fire(ctx, transitionIndex, event)
+		if !fired && len(changes) > 0 {
+			// daten sind übernommen, auch wenn die transition nicht feuert
+			f.record(Event{Type: EventVariablesChanged, TransitionID: event.TransitionID, Actor: event.Actor, Changes: changes})
+		}
+		if fired {
+			// die aufgabe ist erledigt
+			delete(f.Assignees, transitionIndex)
+			return firstError(err, f.Engine().notify(ctx, HookFireCompleted, f, transitionIndex))
 		}
 		return err
 	}
@@ -122,97 +194,185 @@ func (f *Flow) Fire(transitionIndex int, data map[string]interface{}) error {
 }
 
 // checks and notify completion of process and sub process
-func (f *Flow) checkCompleted() bool {
+func (f *Flow) checkCompleted(ctx context.Context) (bool, error) {
 	// onStateChange hier
-
-	if BPNet.OnStateChanged != nil {
-		BPNet.OnStateChanged(f)
-	}
+	e := f.Engine()
+	err := e.stateChanged(ctx, f)
 	if len(f.Net.EnabledTransitions) == 0 {
+		f.Status = StatusCompleted
+		e.Detach(f.ID)
+		f.releaseSingleton()
+		f.record(Event{Type: EventCompleted, Actor: ActorEngine})
 		if f.ParentTransitionTokenID != 0 {
 
 			// fire parent token
+			err = firstError(err, e.notify(ctx, HookSubProcessCompleted, f, f.ParentTransitionTokenID))
 
-			if BPNet.OnSubProcessCompleted != nil {
-				BPNet.OnSubProcessCompleted(f, f.ParentTransitionTokenID)
-			}
-
-			parentFlow, err := BPNet.FlowInstanceLoader(f.ParentID)
-			if err == nil {
-
-				parentFlow.FireSystemTask(f.ParentTransitionTokenID, f.Net.Variables)
+			parentFlow, loadErr := e.loadFlow(ctx, f.ParentID)
+			if loadErr == nil {
+				err = firstError(err, parentFlow.completeTask(ctx, f.ParentTransitionTokenID, f.Net.Variables, ActorSystem))
+			} else {
+				err = firstError(err, HookError{Hook: HookFlowInstanceLoader, Transition: -1, TokenID: f.ParentTransitionTokenID, Err: loadErr})
 			}
 
 		} else {
-			if BPNet.OnProcessCompleted != nil {
-				BPNet.OnProcessCompleted(f, 0)
-			}
+			err = firstError(err, e.notify(ctx, HookProcessCompleted, f, -1))
 		}
-		return true
+		return true, err
 	}
-	return false
+	return false, err
 }
 
-// fire without notification
-func (f *Flow) fire(transitionIndex int) error {
-	err := f.Net.Fire(transitionIndex)
+// fire without notification, the event is recorded before the following transitions are checked.
+// fired is false when the net could not fire, err then is the error of the net.
+func (f *Flow) fire(ctx context.Context, transitionIndex int, event Event) (fired bool, err error) {
+	before := copyTokenIds(f.Net.TokenIds)
+	err = f.netFire(transitionIndex)
 	if err == nil {
-		f.AvailableUserTransitions = f.bpnTransitionsCheck()
-		return nil
+		f.recordFire(event, transitionIndex, before)
+		f.AvailableUserTransitions, err = f.bpnTransitionsCheck(ctx)
+		return true, err
 	}
-	return err
+	return false, err
+}
+
+// records a fire with the consumed and produced tokens
+func (f *Flow) recordFire(event Event, transitionIndex int, before [][]int) {
+	if event.TransitionID == "" {
+		event.TransitionID = f.transitionID(transitionIndex)
+	}
+	event.Consumed, event.Produced = tokenDiff(before, f.Net.TokenIds)
+	if event.TokenID == 0 && len(event.Consumed) > 0 {
+		event.TokenID = event.Consumed[0].TokenID
+	}
+	f.record(event)
 }
 
 // fires a system task with tokenID
 func (f *Flow) FireSystemTask(tokenID int, data map[string]interface{}) error {
+	return f.FireSystemTaskContext(context.Background(), tokenID, data)
+}
+
+// FireSystemTaskContext completes a system task or subprocess, ctx is passed to the hooks.
+// A HookError is returned after the task has fired.
+func (f *Flow) FireSystemTaskContext(ctx context.Context, tokenID int, data map[string]interface{}) error {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	if err := f.checkActive(); err != nil {
+		return err
+	}
+	transition, err := f.taskInProgress(tokenID)
+	if err != nil {
+		return err
+	}
+	if err := f.authorize(ctx, ActionCompleteTask, transition); err != nil {
+		return err
+	}
+	return f.completeTask(ctx, tokenID, data, actorID(ctx, ActorSystem))
+}
+
+// the SYSTEM or SUBPROCESS transition of a token in progress
+func (f *Flow) taskInProgress(tokenID int) (int, error) {
+	transition, ok := f.TransitionsInProgress[tokenID]
+	if !ok || (f.Process.TransitionTypes[transition] != int(SYSTEM) && f.Process.TransitionTypes[transition] != int(SUBPROCESS)) {
+		return 0, fmt.Errorf("token %d is not in a system task", tokenID)
+	}
+	return transition, nil
+}
+
+// completes a system task or subprocess without authorization, completed subflows use it for their parent
+func (f *Flow) completeTask(ctx context.Context, tokenID int, data map[string]interface{}, actor string) error {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	if err := f.checkActive(); err != nil {
+		return err
+	}
+	transition, err := f.taskInProgress(tokenID)
+	if err != nil {
+		return err
+	}
 	// daten einspielen
-	err := f.appendData(data, f.Process.Transitions[f.TransitionsInProgress[tokenID]].ID)
-	if err.Len() == 0 {
-		return f.fireWithTokenId(tokenID)
+	changes, err := f.appendData(data, f.Process.Transitions[transition].ID)
+	if err == nil {
+		event := Event{Type: EventSystemTaskCompleted, TokenID: tokenID, Actor: actor, Changes: changes}
+		if f.Process.TransitionTypes[transition] == int(SUBPROCESS) {
+			event.Type = EventSubflowCompleted
+		}
+		_, err = f.fireWithTokenId(ctx, tokenID, event)
+		return err
 	}
 	return err
 }
 
 // fires a transition from a token in transition
-func (f *Flow) fireWithTokenId(tokenID int) error {
+func (f *Flow) fireWithTokenId(ctx context.Context, tokenID int, event Event) (fired bool, err error) {
 
 	transition := f.TransitionsInProgress[tokenID]
 
-	err := f.Net.FireWithTokenId(transition, tokenID)
+	before := copyTokenIds(f.Net.TokenIds)
+	err = f.netFireWithTokenId(transition, tokenID)
 
 	delete(f.TransitionsInProgress, tokenID)
 
 	if err == nil {
-		f.AvailableUserTransitions = f.bpnTransitionsCheck()
-		return nil
+		f.resolveIncident(tokenID)
+		retryErr := f.cancelRetry(tokenID)
+		f.recordFire(event, transition, before)
+		f.AvailableUserTransitions, err = f.bpnTransitionsCheck(ctx)
+		return true, firstError(retryErr, err)
 	}
 
-	return err
+	return false, err
 }
 
-// check transitions for automatic fire or triggers the different types (AUTO, MESSAGE,...)
-func (f *Flow) bpnTransitionsCheck() []int {
-	f.checkCompleted()
+// check transitions for automatic fire or triggers the different types (AUTO, MESSAGE,...).
+// The first error of a hook is returned, the check goes on with the other transitions.
+func (f *Flow) bpnTransitionsCheck(ctx context.Context) ([]int, error) {
+	_, err := f.checkCompleted(ctx)
+	e := f.Engine()
+
+	// ein angehaltener flow löst nichts aus, Resume holt den check nach
+	if f.Suspended {
+		f.pruneAssignees()
+		return f.Net.EnabledTransitions, err
+	}
 
 	// selbstfeuernde transitionen auslösen
-	for f.hasEnabledAutofireing(f.Net.EnabledTransitions) {
-		for _, transition := range f.Net.EnabledTransitions {
+	// transitionen, deren nachricht nicht gesendet werden konnte, bleiben bis zum nächsten check liegen
+	blocked := make(map[int]bool)
+	for f.hasEnabledAutofireing(f.Net.EnabledTransitions, blocked) {
+		// exklusive entscheidungen nach priorität und default
+		order, choiceErr := f.autofireOrder(blocked)
+		err = firstError(err, choiceErr)
+		for _, transition := range order {
+			if blocked[transition] {
+				continue
+			}
 			// auf alle autofire typen pruefen
 			if f.Process.TransitionTypes[transition] == int(AUTO) {
 				//autofire
-				f.fire(transition)
-
+				fired, fireErr := f.fire(ctx, transition, Event{Type: EventFired, Actor: ActorEngine})
+				if !fired {
+					blocked[transition] = true
+				}
+				err = firstError(err, fireErr)
 				break
 
 			}
 
 			if f.Process.TransitionTypes[transition] == int(MESSAGE) {
-				// send message via extHandler, continue on true
-				if BPNet.OnSendMessage != nil && BPNet.OnSendMessage(f, transition) {
-					f.fire(transition)
-				} else {
-					panic("OnSendMessage not available")
+				// send message via hook, the transition fires only if the message was sent
+				if sendErr := e.sendMessage(ctx, f, transition); sendErr != nil {
+					blocked[transition] = true
+					err = firstError(err, sendErr)
+					break
 				}
+				f.record(Event{Type: EventMessageSent, TransitionID: f.transitionID(transition), Actor: ActorEngine})
+				fired, fireErr := f.fire(ctx, transition, Event{Type: EventFired, Actor: ActorEngine})
+				if !fired {
+					blocked[transition] = true
+				}
+				err = firstError(err, fireErr)
 				break
 			}
 
@@ -226,34 +386,38 @@ func (f *Flow) bpnTransitionsCheck() []int {
 				for _, tokenID := range f.Net.TokenIds[place] {
 
 					// alle noch nicht benachrichtigten timer prüfen
+					// bei ungültigem delay oder fehler im hook bleibt der token liegen
 					if f.Process.TransitionTypes[transition] == int(TIMED) && !f.tokenRegistred(tokenID) {
 						f.TransitionsInProgress[tokenID] = transition
-						executeTimer(f, transition, tokenID)
+						if timerErr := executeTimer(ctx, f, transition, tokenID); timerErr != nil {
+							delete(f.TransitionsInProgress, tokenID)
+							err = firstError(err, timerErr)
+						}
 					}
 
-					// systemtask
+					// systemtask, bei fehler bleibt der token liegen und wird beim nächsten check erneut gesendet
 					if f.Process.TransitionTypes[transition] == int(SYSTEM) && !f.tokenRegistred(tokenID) {
-						if BPNet.OnSystemTask(f, tokenID, transition) {
+						dispatched, taskErr := e.systemTask(ctx, f, tokenID, transition)
+						if dispatched {
 							f.TransitionsInProgress[tokenID] = transition
+							f.record(Event{Type: EventSystemTaskDispatched, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: ActorEngine})
 						}
+						err = firstError(err, taskErr)
 					}
 
 					// subprocess
 					if f.Process.TransitionTypes[transition] == int(SUBPROCESS) && !f.tokenRegistred(tokenID) {
+						err = firstError(err, f.startSubflow(ctx, transition, tokenID))
+					}
 
-						f.TransitionsInProgress[tokenID] = transition
-						// sub erstellen und starten
-						subprocess, err := BPNet.ProcessDefinitionLoader(f.Process.Transitions[transition].Details["process"].(string))
-
-						if err == nil {
-							subflow := subprocess.CreateFlow(f.Owner)
-							subflow.ParentID = f.ID
-							subflow.ParentTransitionTokenID = tokenID
-							f.RunningSubProcesses = append(f.RunningSubProcesses, subflow.ID)
-							//starte mit daten des flows
-							subflow.Start(f.Net.Variables)
-						}
+					// auf eine nachricht warten, bei ungültigen schlüsseln bleibt der token liegen
+					if f.Process.TransitionTypes[transition] == int(RECEIVE) && !f.tokenRegistred(tokenID) {
+						err = firstError(err, f.subscribe(transition, tokenID))
+					}
 
+					// auf ein signal warten
+					if f.Process.TransitionTypes[transition] == int(SIGNAL) && !f.tokenRegistred(tokenID) {
+						f.awaitSignal(transition, tokenID)
 					}
 				}
 			}
@@ -262,31 +426,104 @@ func (f *Flow) bpnTransitionsCheck() []int {
 
 	}
 
-	return f.Net.EnabledTransitions
+	// nachrichten, die vor dem warten ankamen
+	err = firstError(err, f.receiveBuffered(ctx))
+
+	f.pruneAssignees()
+	return f.Net.EnabledTransitions, err
 }
 
-// executes a timer
-func executeTimer(f *Flow, transition int, tokenID int) {
+// creates and starts the subflow of a token.
+// If the process can not be loaded or the subflow does not start, the token stays parked.
+func (f *Flow) startSubflow(ctx context.Context, transition int, tokenID int) error {
+	e := f.Engine()
+	// sub erstellen und starten
+	processName, _ := f.Process.Transitions[transition].Details["process"].(string)
+	subprocess, err := e.loadProcess(ctx, processName)
+	if err != nil {
+		return HookError{Hook: HookProcessDefinitionLoader, Transition: transition, TokenID: tokenID, Err: err}
+	}
 
-	if BPNet.OnTimerStarted != nil {
-		BPNet.OnTimerStarted(f, transition)
+	f.TransitionsInProgress[tokenID] = transition
+	subflow := e.CreateFlow(*subprocess, f.Owner)
+	subflow.ParentID = f.ID
+	subflow.ParentTransitionTokenID = tokenID
+	f.RunningSubProcesses = append(f.RunningSubProcesses, subflow.ID)
+	f.record(Event{Type: EventSubflowStarted, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: ActorEngine, SubflowID: &subflow.ID})
+	//starte mit daten des flows
+	err = subflow.start(ctx, f.Net.Variables)
+	if err != nil && !errors.As(err, &HookError{}) {
+		// subflow läuft nicht, token wieder freigeben
+		delete(f.TransitionsInProgress, tokenID)
+		f.RunningSubProcesses = removeULID(f.RunningSubProcesses, subflow.ID)
+		f.record(Event{Type: EventSubflowFailed, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: ActorEngine, SubflowID: &subflow.ID})
+	}
+	return err
+}
+
+// executes a timer
+func executeTimer(ctx context.Context, f *Flow, transition int, tokenID int) error {
+	e := f.Engine()
+	due, err := dueTime(f.Process.Transitions[transition].Details["delay"], e.clock().Now(), f.Net.Variables)
+	if err != nil {
+		return err
+	}
+	if err := e.notify(ctx, HookTimerStarted, f, transition); err != nil {
+		return err
+	}
+	// ein timer, der nicht gespeichert ist, überlebt keinen neustart
+	if err := e.timerStore().Schedule(ScheduledTimer{FlowID: f.ID, TokenID: tokenID, Transition: transition, Due: due}); err != nil {
+		return err
 	}
 
 	// verzögert auslösen
+	if f.TimersDue == nil {
+		f.TimersDue = make(map[int]time.Time)
+	}
+	f.TimersDue[tokenID] = due
+	f.record(Event{Type: EventTimerArmed, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: ActorEngine, Due: &due})
+	e.armTimer(f, tokenID, due)
+	return nil
+}
 
-	time.AfterFunc(parseDelay(f.Process.Transitions[transition].Details["delay"]), func() {
-		if f.tokenRegistred(tokenID) {
-			err := f.Net.FireWithTokenId(transition, tokenID)
+// fires the transition of an expired timer, the transition is looked up when the timer expires
+func (f *Flow) fireTimer(ctx context.Context, tokenID int) error {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	e := f.Engine()
+	// ein angehaltener flow behält den timer, Resume feuert ihn
+	if f.Suspended && (f.Status == StatusRunning || f.Status == StatusFailed) {
+		return nil
+	}
+	storeErr := e.timerStore().Cancel(f.ID, tokenID)
+	delete(f.TimersDue, tokenID)
+	transition, ok := f.TransitionsInProgress[tokenID]
+	if !ok || f.Status == StatusCancelled {
+		return storeErr
+	}
+	if f.Process.TransitionTypes[transition] == int(SYSTEM) {
+		return firstError(storeErr, f.retrySystemTask(ctx, tokenID, transition))
+	}
+	before := copyTokenIds(f.Net.TokenIds)
+	err := f.netFireWithTokenId(transition, tokenID)
+	if err == nil {
+		f.recordFire(Event{Type: EventTimerFired, TokenID: tokenID, Actor: ActorTimer}, transition, before)
+	}
 
-			if BPNet.OnTimerCompleted != nil {
-				BPNet.OnTimerCompleted(f, transition)
-			}
-			if err == nil {
-				f.AvailableUserTransitions = f.bpnTransitionsCheck()
-			}
-			delete(f.TransitionsInProgress, tokenID)
-		}
-	})
+	hookErr := e.notify(ctx, HookTimerCompleted, f, transition)
+	if err == nil {
+		f.AvailableUserTransitions, err = f.bpnTransitionsCheck(ctx)
+	}
+	delete(f.TransitionsInProgress, tokenID)
+	return firstError(storeErr, firstError(hookErr, err))
+}
+
+// id of a transition, empty for processes without transition details
+func (f *Flow) transitionID(transitionIndex int) string {
+	if transitionIndex >= 0 && transitionIndex < len(f.Process.Transitions) {
+		return f.Process.Transitions[transitionIndex].ID
+	}
+	return ""
 }
 
 // check if a token is already registred in inTransition
@@ -298,8 +535,11 @@ func (f *Flow) tokenRegistred(tokenID int) bool {
 }
 
 // prüfe ob enablete Transitionen mit Autofeuer existieren
-func (f *Flow) hasEnabledAutofireing(enabledTransitions []int) bool {
+func (f *Flow) hasEnabledAutofireing(enabledTransitions []int, blocked map[int]bool) bool {
 	for _, transition := range enabledTransitions {
+		if blocked[transition] {
+			continue
+		}
 		// auf alle autofire pruefen
 		if f.Process.TransitionTypes[transition] == int(AUTO) {
 			return true
@@ -312,24 +552,6 @@ func (f *Flow) hasEnabledAutofireing(enabledTransitions []int) bool {
 	return false
 }
 
-// momentan einfach nur sekunden, aber ist ausbaubar zu eow, eom, 1h,...
-func parseDelay(s interface{}) time.Duration {
-	var delay float64
-	var err error
-	switch s.(type) {
-	case float64:
-		delay = s.(float64)
-	case int:
-		delay = float64(s.(int))
-	}
-
-	if err == nil && delay != 0 {
-		return time.Duration(delay) * 1000 * time.Millisecond
-	} else {
-		return time.Duration(100) * time.Millisecond
-	}
-}
-
 //	die eingebaute max kann nicht mit int umgehen :-(
 //
 // max von zwei Int
@@ -352,11 +574,13 @@ func makeUlid() ulid.ULID {
 
 const (
 	AUTO       TaskType = 1 // feuert selbst ab
-	USER       TaskType = 2 // claim in transition details (assign macht system)
+	USER       TaskType = 2 // kandidaten in transition details users/groups, siehe Task
 	MESSAGE    TaskType = 3 // sendet via Trigger
 	TIMED      TaskType = 4 // feuert durch Zeit
 	SUBPROCESS TaskType = 5 // startet einen subprozess
 	SYSTEM     TaskType = 6 // assign in transition details (claim macht user)
+	RECEIVE    TaskType = 7 // wartet auf eine nachricht, siehe Engine.Correlate
+	SIGNAL     TaskType = 8 // wartet auf ein signal, siehe Engine.Signal
 
 )
 
@@ -364,48 +588,74 @@ type TaskType int
 
 // Flow is a running instance of a process.
 type Flow struct {
-	ID                       ulid.ULID    `json:"id"`                // flow id
-	ProcessName              string       `json:"procname"`          // Network Name
-	ParentID                 ulid.ULID    `json:"parent_process"`    // flow id des parents
-	ParentTransitionTokenID  int          `json:"parent_transition"` // die zu feuernde Transition des Parents bei ende des SubFlows
-	ActivatedSubFlows        []string     `json:"sub_flows"`         // laufende subFlows um bei denen die möglichen Transitionen zu ermitteln (für hateoas)
-	Owner                    string       `json:"owner"`             // Owner
-	AvailableUserTransitions []int        `json:"usertasks"`         // enabled transitions von user tasks
-	TransitionsInProgress    map[int]int  `json:"in_progress"`       // [tokenID]transition enabled timers, ActivatedTimers, subflows,...
-	Net                      petrinet.Net `json:"net"`               // the running net
-	Process                  Process      `json:"process"`
-	RunningSubProcesses      []ulid.ULID  `json:"running_sub_processes"`
+	ID                       ulid.ULID                   `json:"id"`                // flow id
+	ProcessName              string                      `json:"procname"`          // Network Name
+	ProcessVersion           string                      `json:"procversion"`       // version of the process the flow was started with
+	ParentID                 ulid.ULID                   `json:"parent_process"`    // flow id des parents
+	ParentTransitionTokenID  int                         `json:"parent_transition"` // die zu feuernde Transition des Parents bei ende des SubFlows
+	ActivatedSubFlows        []string                    `json:"sub_flows"`         // laufende subFlows um bei denen die möglichen Transitionen zu ermitteln (für hateoas)
+	Owner                    string                      `json:"owner"`             // Owner
+	AvailableUserTransitions []int                       `json:"usertasks"`         // enabled transitions von user tasks
+	TransitionsInProgress    map[int]int                 `json:"in_progress"`       // [tokenID]transition enabled timers, ActivatedTimers, subflows,...
+	TimersDue                map[int]time.Time           `json:"timers_due"`        // [tokenID]due time of armed timers and retries of system tasks
+	Net                      petrinet.Net                `json:"net"`               // the running net
+	Process                  Process                     `json:"process"`
+	RunningSubProcesses      []ulid.ULID                 `json:"running_sub_processes"`
+	EventSeq                 int                         `json:"event_seq"`     // sequence number of the last recorded event
+	TokenCounter             int                         `json:"token_counter"` // last token id issued in this flow
+	SingletonKey             string                      `json:"singleton_key"` // key claimed in the RunningFlowIndex
+	Assignees                map[int]string              `json:"assignees"`     // [transition]user of claimed user tasks
+	Status                   FlowStatus                  `json:"status"`        // running, failed, completed or cancelled, empty before the start
+	CancelReason             string                      `json:"cancel_reason"` // reason given to Cancel
+	Suspended                bool                        `json:"suspended"`     // see Flow.Suspend
+	Incidents                []Incident                  `json:"incidents"`     // failed system tasks without error arcs
+	Attempts                 map[int]int                 `json:"attempts"`      // [tokenID]failed attempts of system tasks waiting for a retry
+	Subscriptions            map[int]MessageSubscription `json:"subscriptions"` // [tokenID]message a RECEIVE transition waits for
+	engine                   *Engine                     // engine with the hooks for this flow
+	mu                       *sync.Mutex                 // serialises starts, fires, timers and system tasks
 }
 
 type Process struct {
-	Name                 string       `json:"name"`        // Network Name
-	InputMatrix          [][]int      `json:"-"`           // Input Matrix
-	OutputMatrix         [][]int      `json:"-"`           // Output Matrix
-	ConditionMatrix      [][]string   `json:"-"`           // Condition Matrix
-	TransitionTypes      []int        `json:"-"`           // Transition Types
-	InitialState         []int        `json:"-"`           // Initial State
-	Transitions          []Transition `json:"transitions"` // detailangaben zur transition
-	Variables            []Variable   `json:"variables"`
-	StartVariables       []string     `json:"startvariables"`
-	SingletonIdentifiers []string     `json:"singletonidentifiers"` // Variable um zu überprüfen dass ein Prozess nur 1x mit dieser läuft
+	Name                 string        `json:"name"`        // Network Name
+	Version              string        `json:"version"`     // version of the definition, see ProcessRef
+	InputMatrix          [][]int       `json:"-"`           // Input Matrix
+	OutputMatrix         [][]int       `json:"-"`           // Output Matrix
+	ConditionMatrix      [][]string    `json:"-"`           // Condition Matrix
+	ArcConditions        [][]string    `json:"-"`           // condition of the input arcs per place, the ConditionMatrix lists them in arc order
+	ErrorMatrix          [][]int       `json:"-"`           // output of failed system tasks, nil rows for transitions without error arcs
+	PriorityMatrix       [][]int       `json:"-"`           // priority of the input arcs, 0 for none, see autofireOrder
+	DefaultMatrix        [][]bool      `json:"-"`           // default input arcs of exclusive choices
+	ChoicePolicies       []string      `json:"-"`           // choice policy per place, ChoiceFirst or ChoiceStrict
+	OutputArcs           [][]OutputArc `json:"-"`           // tp arcs in yaml order of transitions with conditional output, see outputRow
+	TransitionTypes      []int         `json:"-"`           // Transition Types
+	InitialState         []int         `json:"-"`           // Initial State
+	Places               []string      `json:"places"`      // ids of the places, used by Migrate
+	Transitions          []Transition  `json:"transitions"` // detailangaben zur transition
+	Variables            []Variable    `json:"variables"`
+	StartVariables       []string      `json:"startvariables"`
+	SingletonIdentifiers []string      `json:"singletonidentifiers"` // Variable um zu überprüfen dass ein Prozess nur 1x mit dieser läuft
 }
 
+// Deprecated: handler of the default engine, use RegisterHandler or NewEngine
 var BPNet *Handler
 
 type Handler struct {
-	OnProcessStarted        Notify                  `json:"_"` // process started hook, after autofireing hooks
-	OnSystemTask            SystemTask              `json:"_"` //system task handle TODO: check https://siadat.github.io/post/context
-	OnFireCompleted         Notify                  `json:"_"` // nach jedem erfolgreichen Fire
-	OnStateChanged          Change                  `json:"_"` // nach jeder Stateveränderung
-	OnTimerStarted          Notify                  `json:"_"` //timer hook handle
-	OnTimerCompleted        Notify                  `json:"_"` //timer hook handle
-	OnSendMessage           Notify                  `json:"_"` // message send handler
-	OnFlowCreated           Notify                  `json:"_"` // process started hook, after autofireing hooks
-	OnProcessCompleted      Notify                  `json:"_"` // process finished
-	OnSubProcessStarted     Notify                  `json:"_"`
-	OnSubProcessCompleted   Notify                  `json:"_"`
-	FlowInstanceLoader      FlowInstanceLoader      `json:"_"` // prozessinstanzen um parent prozesse oder subprozesse zu referenzieren
-	ProcessDefinitionLoader ProcessDefinitionLoader `json:"_"` // prozessdefinitionen um subprozesse zu starten
+	OnProcessStarted        Notify                  `json:"-"` // process started hook, after autofireing hooks
+	OnSystemTask            SystemTask              `json:"-"` //system task handle, use HandlerV2 for a context
+	OnFireCompleted         Notify                  `json:"-"` // nach jedem erfolgreichen Fire
+	OnStateChanged          Change                  `json:"-"` // nach jeder Stateveränderung
+	OnTimerStarted          Notify                  `json:"-"` //timer hook handle
+	OnTimerCompleted        Notify                  `json:"-"` //timer hook handle
+	OnSendMessage           Notify                  `json:"-"` // message send handler
+	OnFlowCreated           Notify                  `json:"-"` // process started hook, after autofireing hooks
+	OnProcessCompleted      Notify                  `json:"-"` // process finished
+	OnSubProcessStarted     Notify                  `json:"-"`
+	OnSubProcessCompleted   Notify                  `json:"-"`
+	OnProcessCancelled      Notify                  `json:"-"` // flow cancelled, see Flow.Cancel
+	OnSystemTaskCancelled   SystemTask              `json:"-"` // für jeden offenen systemtask eines abgebrochenen flows
+	OnSuspensionChanged     Notify                  `json:"-"` // flow suspended or resumed, see Flow.Suspended
+	FlowInstanceLoader      FlowInstanceLoader      `json:"-"` // prozessinstanzen um parent prozesse oder subprozesse zu referenzieren
+	ProcessDefinitionLoader ProcessDefinitionLoader `json:"-"` // prozessdefinitionen um subprozesse zu starten
 }
 
 // interface um bei autofire zu zünden
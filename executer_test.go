@@ -6,6 +6,7 @@ import (
 
 	"fmt"
 	"github.com/oklog/ulid"
+	"sync"
 	"time"
 )
 
@@ -39,7 +40,9 @@ func TestProcess_CompleteOnce(t *testing.T) {
 	process.InitialState = []int{10, 0, 0, 0, 0, 0, 0, 0, 0}
 	process.TransitionTypes = []int{1, 1, 1, 1, 1, 1, 1}
 	f := process.CreateFlow("veith")
+	completedMu.Lock()
 	completed = 0
+	completedMu.Unlock()
 	FlowCollection[f.ID] = &f
 	var data map[string]interface{}
 	f.Start(data)
@@ -50,7 +53,7 @@ func TestProcess_CompleteOnce(t *testing.T) {
 	}
 	time.Sleep(10 * time.Millisecond)
 	// sollte nur ein mal beenden
-	if completed != 1 {
+	if completedCount() != 1 {
 		t.Error("Should only complete once, is", completed)
 	}
 }
@@ -166,12 +169,14 @@ func TestProcess_TimedUnset(t *testing.T) {
 	f.Start(data)
 
 	time.Sleep(110 * time.Millisecond)
-	if len(f.Net.TokenIds[3]) != 2 {
-		t.Error("Should fired both timers", f.Net.TokenIds)
-	}
-	if f.Net.State[len(f.Net.State)-1] != 2 {
-		t.Error("Should have 2 transition in last place, is", f.Net.State[len(f.Net.State)-1])
-	}
+	f.View(func(f *bpnet.Flow) {
+		if len(f.Net.TokenIds[3]) != 2 {
+			t.Error("Should fired both timers", f.Net.TokenIds)
+		}
+		if f.Net.State[len(f.Net.State)-1] != 2 {
+			t.Error("Should have 2 transition in last place, is", f.Net.State[len(f.Net.State)-1])
+		}
+	})
 }
 func TestProcess_TimedFloat(t *testing.T) {
 	process := freshProcess()
@@ -196,12 +201,14 @@ func TestProcess_TimedFloat(t *testing.T) {
 	f.Start(data)
 
 	time.Sleep(20 * time.Millisecond)
-	if len(f.Net.TokenIds[3]) != 2 {
-		t.Error("Should fired both timers", f.Net.TokenIds)
-	}
-	if f.Net.State[len(f.Net.State)-1] != 2 {
-		t.Error("Should have 2 transition in last place, is", f.Net.State[len(f.Net.State)-1])
-	}
+	f.View(func(f *bpnet.Flow) {
+		if len(f.Net.TokenIds[3]) != 2 {
+			t.Error("Should fired both timers", f.Net.TokenIds)
+		}
+		if f.Net.State[len(f.Net.State)-1] != 2 {
+			t.Error("Should have 2 transition in last place, is", f.Net.State[len(f.Net.State)-1])
+		}
+	})
 }
 func TestProcess_TimedParallel(t *testing.T) {
 	process := freshProcess()
@@ -226,12 +233,14 @@ func TestProcess_TimedParallel(t *testing.T) {
 	f.Start(data)
 
 	time.Sleep(200 * time.Millisecond)
-	if len(f.Net.TokenIds[3]) != 2 {
-		t.Error("Should fired both timers", f.Net.TokenIds)
-	}
-	if f.Net.State[len(f.Net.State)-1] != 2 {
-		t.Error("Should have 2 transition in last place, but have", f.Net.State[len(f.Net.State)-1])
-	}
+	f.View(func(f *bpnet.Flow) {
+		if len(f.Net.TokenIds[3]) != 2 {
+			t.Error("Should fired both timers", f.Net.TokenIds)
+		}
+		if f.Net.State[len(f.Net.State)-1] != 2 {
+			t.Error("Should have 2 transition in last place, but have", f.Net.State[len(f.Net.State)-1])
+		}
+	})
 }
 
 func TestProcess_Timed(t *testing.T) {
@@ -247,10 +256,13 @@ func TestProcess_Timed(t *testing.T) {
 	f := process.CreateFlow("veith")
 	f.Start(data)
 
-	time.Sleep(12 * time.Millisecond)
-	if f.Net.State[len(f.Net.State)-1] != 1 {
-		t.Error("Should have 10 transition in last place, is", f.Net.State[len(f.Net.State)-1])
-	}
+	// 0.01 wurde früher auf 0 abgeschnitten, jetzt wartet der timer 10ms und 12ms lassen unter -race zu wenig luft
+	time.Sleep(20 * time.Millisecond)
+	f.View(func(f *bpnet.Flow) {
+		if f.Net.State[len(f.Net.State)-1] != 1 {
+			t.Error("Should have 10 transition in last place, is", f.Net.State[len(f.Net.State)-1])
+		}
+	})
 }
 
 func TestFlow_Fire2(t *testing.T) {
@@ -466,14 +478,23 @@ func OnProcessStarted(flow *bpnet.Flow, tokenID int) bool {
 }
 
 var completed int
+var completedMu sync.Mutex // flows with timers complete in their own goroutines
 
 func OnProcessCompleted(flow *bpnet.Flow, tokenID int) bool {
 	// subflow starten
 	fmt.Println("COMPLETE process", flow.ID, tokenID)
+	completedMu.Lock()
 	completed += 1
+	completedMu.Unlock()
 	return true
 }
 
+func completedCount() int {
+	completedMu.Lock()
+	defer completedMu.Unlock()
+	return completed
+}
+
 var broker string
 
 func sendMessage(flow *bpnet.Flow, transitionIndex int) bool {
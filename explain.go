@@ -0,0 +1,133 @@
+package bpnet
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/antonmedv/expr"
+	"github.com/oklog/ulid"
+)
+
+// Explanation tells why a transition of a flow is enabled or not
+type Explanation struct {
+	FlowID     ulid.ULID              `json:"flow"`
+	Transition string                 `json:"transition"`
+	Enabled    bool                   `json:"enabled"` // as seen by the net, Fire checks the same
+	Status     FlowStatus             `json:"status"`
+	Suspended  bool                   `json:"suspended"`
+	Places     []PlaceExplanation     `json:"places"`
+	Conditions []ConditionExplanation `json:"conditions"`
+	Parked     []ParkedToken          `json:"parked"`
+}
+
+// PlaceExplanation is an input place of the transition with the tokens needed and present
+type PlaceExplanation struct {
+	Place   string `json:"place"`
+	Needed  int    `json:"needed"`
+	Present int    `json:"present"`
+}
+
+// Missing returns the number of tokens the place is short of
+func (p PlaceExplanation) Missing() int {
+	return max(p.Needed-p.Present, 0)
+}
+
+// ConditionExplanation is a condition on an input arc with its result for the current variables
+type ConditionExplanation struct {
+	Place     string `json:"place"`
+	Condition string `json:"condition"`
+	Result    bool   `json:"result"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ParkedToken is a token on an input place which waits in a timer, system task, subflow, message or signal
+type ParkedToken struct {
+	TokenID    int    `json:"token"`
+	Place      string `json:"place"`
+	Transition string `json:"transition"`
+}
+
+// Explain diagnoses a transition of the flow: the tokens missing per input place,
+// each arc condition with its result or evaluation error and the tokens of the input
+// places which are already parked in TransitionsInProgress.
+func (f *Flow) Explain(transitionID string) (Explanation, error) {
+	var explanation Explanation
+	var err error
+	f.View(func(f *Flow) {
+		explanation, err = f.explain(transitionID)
+	})
+	return explanation, err
+}
+
+func (f *Flow) explain(transitionID string) (Explanation, error) {
+	transition := -1
+	for index, candidate := range f.Process.Transitions {
+		if candidate.ID == transitionID {
+			transition = index
+		}
+	}
+	if transition < 0 || transition >= len(f.Net.InputMatrix) {
+		return Explanation{}, fmt.Errorf("transition %q does not exist in process %q", transitionID, f.Process.Name)
+	}
+
+	explanation := Explanation{
+		FlowID:     f.ID,
+		Transition: transitionID,
+		Enabled:    f.Net.TransitionEnabled(transition),
+		Status:     f.Status,
+		Suspended:  f.Suspended,
+	}
+	for place, needed := range f.Net.InputMatrix[transition] {
+		if needed == 0 {
+			continue
+		}
+		present := 0
+		if place < len(f.Net.State) {
+			present = f.Net.State[place]
+		}
+		explanation.Places = append(explanation.Places, PlaceExplanation{Place: f.placeID(place), Needed: needed, Present: present})
+
+		if condition := f.Process.arcCondition(transition, place); condition != "" {
+			explanation.Conditions = append(explanation.Conditions, f.explainCondition(place, condition))
+		}
+
+		if place >= len(f.Net.TokenIds) {
+			continue
+		}
+		for _, tokenID := range f.Net.TokenIds[place] {
+			if parked, ok := f.TransitionsInProgress[tokenID]; ok {
+				explanation.Parked = append(explanation.Parked, ParkedToken{TokenID: tokenID, Place: f.placeID(place), Transition: f.transitionID(parked)})
+			}
+		}
+	}
+	sort.Slice(explanation.Parked, func(i, j int) bool {
+		return explanation.Parked[i].TokenID < explanation.Parked[j].TokenID
+	})
+	return explanation, nil
+}
+
+func (p Process) arcCondition(transition int, place int) string {
+	if transition < len(p.ArcConditions) && place < len(p.ArcConditions[transition]) {
+		return p.ArcConditions[transition][place]
+	}
+	return ""
+}
+
+// der vendored net wertet fehler als false, hier wird der fehler gemeldet
+func (f *Flow) explainCondition(place int, condition string) ConditionExplanation {
+	explanation := ConditionExplanation{Place: f.placeID(place), Condition: condition}
+	result, err := expr.Eval(condition, f.Net.Variables)
+	switch {
+	case err != nil:
+		explanation.Error = err.Error()
+	case result == nil:
+		explanation.Error = "condition has no result"
+	default:
+		ok, isBool := result.(bool)
+		if !isBool {
+			explanation.Error = fmt.Sprintf("condition returns %T, not bool", result)
+		}
+		explanation.Result = ok
+	}
+	return explanation
+}
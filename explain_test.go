@@ -0,0 +1,82 @@
+package bpnet_test
+
+import (
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// test/explain.yaml: review + signoff -> approve (user) -> done, review -> check (system) -> done
+
+func startExplainFlow(t *testing.T) bpnet.Flow {
+	f := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool { return true },
+	}).CreateFlow(buildfile(t, "test/explain.yaml"), "veith")
+	if err := f.Start(map[string]interface{}{"amount": 5}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestExplain(t *testing.T) {
+	f := startExplainFlow(t)
+
+	explanation, err := f.Explain("approve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Enabled || explanation.Transition != "approve" || explanation.Status != bpnet.StatusRunning {
+		t.Error("approve should not be enabled", explanation)
+	}
+	if len(explanation.Places) != 2 || explanation.Places[0].Missing() != 0 || explanation.Places[1].Place != "signoff" || explanation.Places[1].Missing() != 1 {
+		t.Error("signoff should be short of one token", explanation.Places)
+	}
+	if len(explanation.Conditions) != 1 || explanation.Conditions[0].Result || explanation.Conditions[0].Error != "" || explanation.Conditions[0].Place != "review" {
+		t.Error("condition should be false", explanation.Conditions)
+	}
+	if len(explanation.Parked) != 1 || explanation.Parked[0].Transition != "check" || explanation.Parked[0].Place != "review" {
+		t.Error("review token should be parked in the system task", explanation.Parked)
+	}
+}
+
+func TestExplain_ConditionOnSecondPlace(t *testing.T) {
+	// die bedingung hängt am arc von signoff statt review
+	process := buildfile(t, "test/explain.yaml", func(net *bpnet.ImportNet) {
+		net.Arc[0].Condition = ""
+		net.Arc[1].Condition = "amount > 100"
+	})
+	f := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool { return true },
+	}).CreateFlow(process, "veith")
+	if err := f.Start(map[string]interface{}{"amount": 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	explanation, err := f.Explain("approve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.Conditions) != 1 || explanation.Conditions[0].Place != "signoff" || explanation.Conditions[0].Condition != "amount > 100" {
+		t.Error("condition should be reported under signoff", explanation.Conditions)
+	}
+}
+
+func TestExplain_ConditionError(t *testing.T) {
+	f := startExplainFlow(t)
+	f.Net.Variables["amount"] = "many"
+
+	explanation, err := f.Explain("approve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.Conditions) != 1 || explanation.Conditions[0].Result || explanation.Conditions[0].Error == "" {
+		t.Error("evaluation error should be reported", explanation.Conditions)
+	}
+}
+
+func TestExplain_UnknownTransition(t *testing.T) {
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(buildfile(t, "test/explain.yaml"), "veith")
+	if _, err := f.Explain("reject"); err == nil {
+		t.Error("unknown transition should be reported")
+	}
+}
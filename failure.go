@@ -0,0 +1,164 @@
+package bpnet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// StatusFailed marks a flow with an open Incident, the other branches keep running
+const StatusFailed FlowStatus = "failed"
+
+// DefaultErrorVariable takes the error message of a failed system task,
+// the transition detail "errorvariable" names another variable
+const DefaultErrorVariable = "error"
+
+// Incident is a failed system task without error arcs. The token stays in progress,
+// the incident is resolved when the task is completed with FireSystemTask.
+type Incident struct {
+	FlowID       ulid.ULID `json:"flow"`
+	TokenID      int       `json:"token"`
+	Transition   int       `json:"transition"`
+	TransitionID string    `json:"transition_id"`
+	Error        string    `json:"error"`
+	Time         time.Time `json:"time"`
+}
+
+// FailSystemTask reports a failed system task, see FailSystemTaskContext
+func (f *Flow) FailSystemTask(tokenID int, failure error) error {
+	return f.FailSystemTaskContext(context.Background(), tokenID, failure)
+}
+
+// FailSystemTaskContext reports that the work of a system task failed. A transition with a
+// RetryPolicy dispatches the task again until the attempts run out. Then, with error arcs
+// (arc type "error" in the yaml), the token moves to the error places and the message is
+// stored in the error variable. Without error arcs an Incident is raised and the flow is failed.
+func (f *Flow) FailSystemTaskContext(ctx context.Context, tokenID int, failure error) error {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	if err := f.checkActive(); err != nil {
+		return err
+	}
+	transition, ok := f.TransitionsInProgress[tokenID]
+	if !ok || f.Process.TransitionTypes[transition] != int(SYSTEM) {
+		return fmt.Errorf("token %d is not in a system task", tokenID)
+	}
+	if err := f.authorize(ctx, ActionCompleteTask, transition); err != nil {
+		return err
+	}
+	return f.failSystemTask(ctx, tokenID, transition, failure, actorID(ctx, ActorSystem))
+}
+
+// retries or escalates a failed system task
+func (f *Flow) failSystemTask(ctx context.Context, tokenID int, transition int, failure error, actor string) error {
+	message := "system task failed"
+	if failure != nil {
+		message = failure.Error()
+	}
+	if policy, ok := f.Process.retryPolicy(transition); ok {
+		if failed := f.Attempts[tokenID] + 1; failed < policy.Attempts {
+			return f.scheduleRetry(tokenID, transition, failed, policy.backoff(failed), message, actor)
+		}
+	}
+	delete(f.Attempts, tokenID)
+
+	if !f.Process.hasErrorRoute(transition) {
+		f.raiseIncident(tokenID, transition, message, f.Engine().clock().Now())
+		f.record(Event{Type: EventIncident, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: actor, Error: message})
+		return nil
+	}
+
+	before := copyTokenIds(f.Net.TokenIds)
+	if err := f.netFireError(transition, tokenID); err != nil {
+		return err
+	}
+	delete(f.TransitionsInProgress, tokenID)
+	f.resolveIncident(tokenID)
+
+	// fehlermeldung für den fehlerzweig ablegen
+	name := f.Process.errorVariable(transition)
+	data := map[string]interface{}{name: message}
+	if coerced, err := f.coerceData(data); err == nil && coerced[name] != nil {
+		data = coerced
+	}
+	changes := variableChanges(f.Net.Variables, data)
+	f.Net.Variables[name] = data[name]
+
+	f.recordFire(Event{Type: EventSystemTaskFailed, TokenID: tokenID, Actor: actor, Changes: changes, Error: message}, transition, before)
+	var err error
+	f.AvailableUserTransitions, err = f.bpnTransitionsCheck(ctx)
+	return err
+}
+
+// fires a transition over its error arcs instead of its output arcs
+func (f *Flow) netFireError(transitionIndex int, tokenID int) error {
+	return f.netFireOutput(transitionIndex, tokenID, f.Process.ErrorMatrix[transitionIndex])
+}
+
+func (p Process) hasErrorRoute(transitionIndex int) bool {
+	if transitionIndex >= len(p.ErrorMatrix) {
+		return false
+	}
+	for _, weight := range p.ErrorMatrix[transitionIndex] {
+		if weight > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Process) errorVariable(transitionIndex int) string {
+	if transitionIndex < len(p.Transitions) {
+		if name, _ := p.Transitions[transitionIndex].Details["errorvariable"].(string); name != "" {
+			return name
+		}
+	}
+	return DefaultErrorVariable
+}
+
+func (f *Flow) raiseIncident(tokenID int, transition int, message string, at time.Time) {
+	f.resolveIncident(tokenID)
+	f.Incidents = append(f.Incidents, Incident{FlowID: f.ID, TokenID: tokenID, Transition: transition, TransitionID: f.transitionID(transition), Error: message, Time: at})
+	if f.Status == StatusRunning {
+		f.Status = StatusFailed
+	}
+}
+
+// removes the incident of a token, the flow runs again when no incident is left
+func (f *Flow) resolveIncident(tokenID int) {
+	for i, incident := range f.Incidents {
+		if incident.TokenID == tokenID {
+			f.Incidents = append(f.Incidents[:i:i], f.Incidents[i+1:]...)
+			break
+		}
+	}
+	if len(f.Incidents) == 0 {
+		f.Incidents = nil
+		if f.Status == StatusFailed {
+			f.Status = StatusRunning
+		}
+	}
+}
+
+// Incidents returns the open incidents of all flows of the engine, oldest first
+func (e *Engine) Incidents() []Incident {
+	var incidents []Incident
+	for _, flow := range e.Flows() {
+		flow.View(func(flow *Flow) {
+			incidents = append(incidents, flow.Incidents...)
+		})
+	}
+	sort.Slice(incidents, func(i, j int) bool {
+		if !incidents[i].Time.Equal(incidents[j].Time) {
+			return incidents[i].Time.Before(incidents[j].Time)
+		}
+		if incidents[i].FlowID != incidents[j].FlowID {
+			return incidents[i].FlowID.Compare(incidents[j].FlowID) < 0
+		}
+		return incidents[i].TokenID < incidents[j].TokenID
+	})
+	return incidents
+}
@@ -0,0 +1,87 @@
+package bpnet_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// test/failure.yaml: start -> call (system) -> done, call -error-> failed -> handle (user) -> handled
+func withoutErrorArcs(net *bpnet.ImportNet) {
+	var arcs []bpnet.Arc
+	for _, arc := range net.Arc {
+		if arc.Type != "error" {
+			arcs = append(arcs, arc)
+		}
+	}
+	net.Arc = arcs
+}
+
+func TestFailSystemTask_ErrorArc(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool {
+			return true
+		},
+	})
+	e.History = sink
+	f := e.CreateFlow(buildfile(t, "test/failure.yaml"), "veith")
+	f.Start(nil)
+
+	if err := f.FailSystemTask(1, errors.New("api timeout")); err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[1] != 0 || f.Net.State[2] != 1 {
+		t.Error("token should move to the error place, state", f.Net.State)
+	}
+	if f.Net.Variables["reason"] != "api timeout" {
+		t.Error("error should be stored in the error variable, have", f.Net.Variables)
+	}
+	if len(f.TransitionsInProgress) != 0 || f.Status != bpnet.StatusRunning {
+		t.Error("failed task should end, in progress", f.TransitionsInProgress, f.Status)
+	}
+	if err := bpnet.VerifyHistory(&f, sink.Events(f.ID)); err != nil {
+		t.Error("history should match the flow", err)
+	}
+	if err := f.Fire(1, nil); err != nil {
+		t.Error("error branch should be enabled", err)
+	}
+}
+
+func TestFailSystemTask_Incident(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool {
+			return true
+		},
+	})
+	e.History = sink
+	f := e.CreateFlow(buildfile(t, "test/failure.yaml", withoutErrorArcs), "veith")
+	f.Start(nil)
+
+	if err := f.FailSystemTask(1, errors.New("api timeout")); err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != bpnet.StatusFailed {
+		t.Error("flow without error arc should fail, status", f.Status)
+	}
+	incidents := e.Incidents()
+	if len(incidents) != 1 || incidents[0].FlowID != f.ID || incidents[0].TransitionID != "call" || incidents[0].Error != "api timeout" {
+		t.Fatal("incident should be listed, have", incidents)
+	}
+	if err := bpnet.VerifyHistory(&f, sink.Events(f.ID)); err != nil {
+		t.Error("history should match the flow", err)
+	}
+
+	// nach der behebung wird der task normal abgeschlossen
+	if err := f.FireSystemTask(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != bpnet.StatusCompleted || len(f.Incidents) != 0 {
+		t.Error("completed task should resolve the incident, status", f.Status, f.Incidents)
+	}
+	if err := f.FailSystemTask(1, errors.New("late")); err == nil {
+		t.Error("token which is not in a system task should not fail")
+	}
+}
@@ -3,6 +3,7 @@ module github.com/veith/bpnet
 go 1.18
 
 require (
+	github.com/antonmedv/expr v1.12.3
 	github.com/ghodss/yaml v1.0.0
 	github.com/oklog/ulid v1.3.1
 	github.com/veith/petrinet v0.3.0
@@ -10,6 +11,5 @@ require (
 )
 
 require (
-	github.com/antonmedv/expr v1.12.3 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,222 @@
+package bpnet
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// EventType is the kind of an entry in the history of a flow
+type EventType string
+
+const (
+	EventStarted              EventType = "started"
+	EventFired                EventType = "fired"
+	EventTimerArmed           EventType = "timer_armed"
+	EventTimerFired           EventType = "timer_fired"
+	EventMessageSent          EventType = "message_sent"
+	EventSystemTaskDispatched EventType = "systemtask_dispatched"
+	EventSystemTaskCompleted  EventType = "systemtask_completed"
+	EventSystemTaskFailed     EventType = "systemtask_failed" // the token moved over the error arcs
+	EventIncident             EventType = "incident"          // failed system task without error arcs
+	EventRetryScheduled       EventType = "retry_scheduled"   // failed system task, dispatched again at Due
+	EventSystemTaskRetried    EventType = "systemtask_retried"
+	EventMessageAwaited       EventType = "message_awaited"
+	EventMessageReceived      EventType = "message_received"
+	EventSignalAwaited        EventType = "signal_awaited"
+	EventSignalReceived       EventType = "signal_received"
+	EventSubflowStarted       EventType = "subflow_started"
+	EventSubflowCompleted     EventType = "subflow_completed"
+	EventSubflowFailed        EventType = "subflow_failed" // the subflow did not start, the token is parked again
+	EventVariablesChanged     EventType = "variables_changed"
+	EventCompleted            EventType = "completed"
+	EventTaskClaimed          EventType = "task_claimed"
+	EventTaskUnclaimed        EventType = "task_unclaimed"
+	EventTaskAssigned         EventType = "task_assigned" // assigned or delegated
+	EventCancelled            EventType = "cancelled"
+	EventSuspended            EventType = "suspended"
+	EventResumed              EventType = "resumed"
+	EventMigrated             EventType = "migrated" // the tokens moved from the places of FromVersion to the places of ToVersion
+)
+
+// actors of events which are not triggered by a caller
+const (
+	ActorEngine = "engine" // autofire of AUTO and MESSAGE transitions
+	ActorTimer  = "timer"  // expired timers
+	ActorSystem = "system" // completed system tasks and subflows
+)
+
+// Event is an entry in the append-only history of a flow
+type Event struct {
+	FlowID       ulid.ULID         `json:"flow"`
+	Seq          int               `json:"seq"` // position in the history of the flow, starting with 1
+	Time         time.Time         `json:"time"`
+	Type         EventType         `json:"type"`
+	TransitionID string            `json:"transition,omitempty"`
+	TokenID      int               `json:"token,omitempty"`
+	Actor        string            `json:"actor,omitempty"`
+	Changes      []VariableChange  `json:"changes,omitempty"`      // changed variables
+	Consumed     []PlacedToken     `json:"consumed,omitempty"`     // tokens removed by a fire
+	Produced     []PlacedToken     `json:"produced,omitempty"`     // tokens created by a fire or the start
+	Due          *time.Time        `json:"due,omitempty"`          // due time of an armed timer
+	SubflowID    *ulid.ULID        `json:"subflow,omitempty"`      // started subflow
+	Assignee     string            `json:"assignee,omitempty"`     // new assignee of a user task
+	Reason       string            `json:"reason,omitempty"`       // reason of a cancel
+	Error        string            `json:"error,omitempty"`        // message of a failed system task
+	Attempt      int               `json:"attempt,omitempty"`      // attempt of a retried system task, starting with 1
+	Message      string            `json:"message,omitempty"`      // name of an awaited or received message
+	Correlation  map[string]string `json:"correlation,omitempty"`  // correlation keys of an awaited message
+	Signal       string            `json:"signal,omitempty"`       // name of an awaited or received signal
+	FromVersion  string            `json:"from_version,omitempty"` // process version before a migration
+	ToVersion    string            `json:"to_version,omitempty"`   // process version after a migration
+	Renamed      map[string]string `json:"renamed,omitempty"`      // old -> new transition ids of a migration
+}
+
+// VariableChange is the value of a variable before and after an event
+type VariableChange struct {
+	Name   string      `json:"name"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// PlacedToken is a token on a place (index of the place)
+type PlacedToken struct {
+	Place   int `json:"place"`
+	TokenID int `json:"token"`
+}
+
+// EventSink stores the events of the flows
+type EventSink interface {
+	Append(event Event) error
+}
+
+// JSONLinesSink writes every event as one line of json
+type JSONLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONLinesSink) Append(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(event)
+}
+
+// MemorySink keeps the events in memory
+type MemorySink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *MemorySink) Append(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+// Events returns the history of a flow
+func (s *MemorySink) Events(flowID ulid.ULID) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []Event
+	for _, event := range s.events {
+		if event.FlowID == flowID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// appends an event to the history of the flow. Errors of the sink do not stop the flow,
+// they go to OnHistoryError and the sequence number is not used up.
+func (f *Flow) record(event Event) {
+	e := f.Engine()
+	if e.History == nil {
+		return
+	}
+	event.FlowID = f.ID
+	event.Seq = f.EventSeq + 1
+	event.Time = e.clock().Now()
+	if err := e.History.Append(event); err != nil {
+		if e.OnHistoryError != nil {
+			e.OnHistoryError(event, err)
+		}
+		return
+	}
+	f.EventSeq = event.Seq
+}
+
+// compares variables before and after new data was taken over
+func variableChanges(before map[string]interface{}, data map[string]interface{}) []VariableChange {
+	var changes []VariableChange
+	for name, after := range data {
+		old, ok := before[name]
+		if ok && reflect.DeepEqual(old, after) {
+			continue
+		}
+		changes = append(changes, VariableChange{Name: name, Before: old, After: after})
+	}
+	// stabile reihenfolge für das log
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+func copyTokenIds(tokenIds [][]int) [][]int {
+	c := make([][]int, len(tokenIds))
+	for i, ids := range tokenIds {
+		c[i] = append([]int(nil), ids...)
+	}
+	return c
+}
+
+// finds the tokens removed and created between two markings
+func tokenDiff(before, after [][]int) (consumed, produced []PlacedToken) {
+	for place := 0; place < len(before) || place < len(after); place++ {
+		var b, a []int
+		if place < len(before) {
+			b = before[place]
+		}
+		if place < len(after) {
+			a = after[place]
+		}
+		for _, id := range b {
+			if !containsInt(a, id) {
+				consumed = append(consumed, PlacedToken{Place: place, TokenID: id})
+			}
+		}
+		for _, id := range a {
+			if !containsInt(b, id) {
+				produced = append(produced, PlacedToken{Place: place, TokenID: id})
+			}
+		}
+	}
+	return consumed, produced
+}
+
+func removeULID(list []ulid.ULID, item ulid.ULID) []ulid.ULID {
+	for i, id := range list {
+		if id == item {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+func containsInt(list []int, item int) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package bpnet_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/veith/bpnet"
+)
+
+// runs test/history.yaml to the end: start -> approve (user) -> p1 -> wait (timed) -> p2 -> book (system) -> end
+func runHistoryProcess(t *testing.T, sink bpnet.EventSink) (*bpnet.Flow, *manualClock) {
+	clock := newManualClock()
+	var systemToken int
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool {
+			systemToken = tokenID
+			return true
+		},
+	})
+	e.Clock = clock
+	e.History = sink
+
+	f := e.CreateFlow(buildfile(t, "test/history.yaml"), "veith")
+	if err := f.Start(map[string]interface{}{"note": "draft"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Fire(0, map[string]interface{}{"note": "approved"}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if err := f.FireSystemTask(systemToken, map[string]interface{}{"booking": 42}); err != nil {
+		t.Fatal(err)
+	}
+	return &f, clock
+}
+
+func TestHistory_Events(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	f, clock := runHistoryProcess(t, sink)
+	events := sink.Events(f.ID)
+
+	expected := []bpnet.EventType{
+		bpnet.EventStarted,
+		bpnet.EventFired,
+		bpnet.EventTimerArmed,
+		bpnet.EventTimerFired,
+		bpnet.EventSystemTaskDispatched,
+		bpnet.EventSystemTaskCompleted,
+		bpnet.EventCompleted,
+	}
+	if len(events) != len(expected) {
+		t.Fatal("should record", len(expected), "events, got", events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i] {
+			t.Error("event", i, "should be", expected[i], "is", event.Type)
+		}
+		if event.Seq != i+1 {
+			t.Error("event", i, "should have seq", i+1, "has", event.Seq)
+		}
+	}
+
+	fired := events[1]
+	if fired.TransitionID != "approve" || fired.Actor != "veith" || fired.TokenID == 0 {
+		t.Error("fired event should name transition, actor and token", fired)
+	}
+	if len(fired.Changes) != 1 || fired.Changes[0].Before != "draft" || fired.Changes[0].After != "approved" {
+		t.Error("fired event should record the changed variable", fired.Changes)
+	}
+	if armed := events[2]; armed.Due == nil || !armed.Due.Equal(clock.Now()) {
+		t.Error("armed timer should record its due time", armed.Due)
+	}
+	if timer := events[3]; timer.Actor != bpnet.ActorTimer || len(timer.Consumed) != 1 || len(timer.Produced) != 1 {
+		t.Error("timer event should record the moved token", timer)
+	}
+	if completed := events[5]; completed.TokenID != events[4].TokenID || completed.Changes[0].Name != "booking" {
+		t.Error("completed system task should record its token and data", completed)
+	}
+}
+
+func TestHistory_JSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	runHistoryProcess(t, bpnet.NewJSONLinesSink(&buf))
+
+	scanner := bufio.NewScanner(&buf)
+	lines := 0
+	for scanner.Scan() {
+		var event bpnet.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Error("line should be an event", scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != 7 {
+		t.Error("should write 7 lines, wrote", lines)
+	}
+}
+
+// refuses every second event
+type flakySink struct {
+	bpnet.MemorySink
+	calls int
+}
+
+func (s *flakySink) Append(event bpnet.Event) error {
+	s.calls++
+	if s.calls%2 == 0 {
+		return errors.New("disk full")
+	}
+	return s.MemorySink.Append(event)
+}
+
+func TestHistory_SinkError(t *testing.T) {
+	sink := &flakySink{}
+	var refused []bpnet.Event
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.History = sink
+	e.OnHistoryError = func(event bpnet.Event, err error) {
+		refused = append(refused, event)
+	}
+
+	f := e.CreateFlow(timedProcess(60), "veith")
+	f.Start(nil)
+	events := sink.Events(f.ID)
+	if len(refused) == 0 || len(events)+len(refused) != sink.calls {
+		t.Fatal("refused events should go to OnHistoryError", refused)
+	}
+	for i, event := range events {
+		if event.Seq != i+1 {
+			t.Error("refused events should not leave gaps in the sequence", events)
+		}
+	}
+	if f.EventSeq != len(events) {
+		t.Error("EventSeq should count the stored events", f.EventSeq)
+	}
+}
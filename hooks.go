@@ -0,0 +1,205 @@
+package bpnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/oklog/ulid"
+)
+
+// HandlerV2 are the hooks of an engine with context and error.
+// A hook which is set in HandlerV2 replaces the same hook of the Handler.
+//
+// Errors of the hooks:
+//   - OnSendMessage: the message transition does not fire, the token stays on its place
+//   - OnSystemTask: the token stays parked, the task is dispatched again with the next state change
+//   - OnTimerStarted: the timer is not armed, the token stays parked like with OnSystemTask
+//   - loaders: a subprocess is not started, its token stays parked like with OnSystemTask
+//   - all other hooks: the state change is kept
+//
+// Start, Fire and FireSystemTask return the first error of a hook as HookError.
+// Hooks of the flow (started, completed, cancelled, suspension) get -1 as transition.
+type HandlerV2 struct {
+	OnProcessStarted        NotifyV2
+	OnSystemTask            SystemTaskV2
+	OnFireCompleted         NotifyV2
+	OnStateChanged          ChangeV2
+	OnTimerStarted          NotifyV2
+	OnTimerCompleted        NotifyV2
+	OnSendMessage           NotifyV2
+	OnProcessCompleted      NotifyV2
+	OnSubProcessStarted     NotifyV2
+	OnSubProcessCompleted   NotifyV2
+	OnProcessCancelled      NotifyV2
+	OnSystemTaskCancelled   SystemTaskV2
+	OnSuspensionChanged     NotifyV2
+	FlowInstanceLoader      FlowInstanceLoaderV2
+	ProcessDefinitionLoader ProcessDefinitionLoaderV2
+}
+
+type NotifyV2 func(ctx context.Context, flow *Flow, transitionIndex int) error
+type ChangeV2 func(ctx context.Context, flow *Flow) error
+type SystemTaskV2 func(ctx context.Context, flow *Flow, tokenID int, transitionIndex int) error
+type ProcessDefinitionLoaderV2 func(ctx context.Context, processName string) (*Process, error)
+type FlowInstanceLoaderV2 func(ctx context.Context, flowID ulid.ULID) (*Flow, error)
+
+// names of the hooks in HookError
+const (
+	HookProcessStarted          = "OnProcessStarted"
+	HookSystemTask              = "OnSystemTask"
+	HookFireCompleted           = "OnFireCompleted"
+	HookStateChanged            = "OnStateChanged"
+	HookTimerStarted            = "OnTimerStarted"
+	HookTimerCompleted          = "OnTimerCompleted"
+	HookSendMessage             = "OnSendMessage"
+	HookProcessCompleted        = "OnProcessCompleted"
+	HookSubProcessStarted       = "OnSubProcessStarted"
+	HookSubProcessCompleted     = "OnSubProcessCompleted"
+	HookProcessCancelled        = "OnProcessCancelled"
+	HookSystemTaskCancelled     = "OnSystemTaskCancelled"
+	HookSuspensionChanged       = "OnSuspensionChanged"
+	HookFlowInstanceLoader      = "FlowInstanceLoader"
+	HookProcessDefinitionLoader = "ProcessDefinitionLoader"
+)
+
+// ErrNotHandled is the error of a legacy hook which is missing or returned false
+var ErrNotHandled = errors.New("not handled")
+
+// HookError is returned when a hook failed
+type HookError struct {
+	Hook       string // name of the hook
+	Transition int    // index of the transition, -1 for hooks of the flow
+	TokenID    int    // token of system tasks and subprocesses
+	Err        error
+}
+
+func (e HookError) Error() string {
+	return fmt.Sprintf("%s (transition %d, token %d): %v", e.Hook, e.Transition, e.TokenID, e.Err)
+}
+
+func (e HookError) Unwrap() error {
+	return e.Err
+}
+
+// keeps the first error, later ones are dropped
+func firstError(err error, next error) error {
+	if err != nil {
+		return err
+	}
+	return next
+}
+
+// returns the hooks v2 of the engine, never nil
+func (e *Engine) hooks() *HandlerV2 {
+	if e.Hooks != nil {
+		return e.Hooks
+	}
+	return &HandlerV2{}
+}
+
+// picks a notification hook by name
+func (e *Engine) notifyHook(hook string) (NotifyV2, Notify) {
+	v2, h := e.hooks(), e.handler()
+	switch hook {
+	case HookProcessStarted:
+		return v2.OnProcessStarted, h.OnProcessStarted
+	case HookFireCompleted:
+		return v2.OnFireCompleted, h.OnFireCompleted
+	case HookTimerStarted:
+		return v2.OnTimerStarted, h.OnTimerStarted
+	case HookTimerCompleted:
+		return v2.OnTimerCompleted, h.OnTimerCompleted
+	case HookSendMessage:
+		return v2.OnSendMessage, h.OnSendMessage
+	case HookProcessCompleted:
+		return v2.OnProcessCompleted, h.OnProcessCompleted
+	case HookSubProcessStarted:
+		return v2.OnSubProcessStarted, h.OnSubProcessStarted
+	case HookSubProcessCompleted:
+		return v2.OnSubProcessCompleted, h.OnSubProcessCompleted
+	case HookProcessCancelled:
+		return v2.OnProcessCancelled, h.OnProcessCancelled
+	case HookSuspensionChanged:
+		return v2.OnSuspensionChanged, h.OnSuspensionChanged
+	}
+	return nil, nil
+}
+
+func (e *Engine) hasHook(hook string) bool {
+	v2, legacy := e.notifyHook(hook)
+	return v2 != nil || legacy != nil
+}
+
+// calls a notification hook, the result of a legacy hook is ignored.
+// Legacy hooks of the flow get 0 instead of -1 like before.
+func (e *Engine) notify(ctx context.Context, hook string, flow *Flow, transitionIndex int) error {
+	v2, legacy := e.notifyHook(hook)
+	if v2 != nil {
+		if err := v2(ctx, flow, transitionIndex); err != nil {
+			return HookError{Hook: hook, Transition: transitionIndex, Err: err}
+		}
+		return nil
+	}
+	if legacy != nil {
+		legacy(flow, max(transitionIndex, 0))
+	}
+	return nil
+}
+
+// sends a message, a missing hook or false of a legacy hook is an error
+func (e *Engine) sendMessage(ctx context.Context, flow *Flow, transitionIndex int) error {
+	if v2 := e.hooks().OnSendMessage; v2 != nil {
+		if err := v2(ctx, flow, transitionIndex); err != nil {
+			return HookError{Hook: HookSendMessage, Transition: transitionIndex, Err: err}
+		}
+		return nil
+	}
+	if h := e.handler(); h.OnSendMessage != nil && h.OnSendMessage(flow, transitionIndex) {
+		return nil
+	}
+	return HookError{Hook: HookSendMessage, Transition: transitionIndex, Err: ErrNotHandled}
+}
+
+// dispatches a system task. false of a legacy hook parks the token without error
+func (e *Engine) systemTask(ctx context.Context, flow *Flow, tokenID int, transitionIndex int) (bool, error) {
+	ctx = context.WithValue(ctx, attemptKey{}, flow.Attempts[tokenID]+1)
+	if v2 := e.hooks().OnSystemTask; v2 != nil {
+		if err := v2(ctx, flow, tokenID, transitionIndex); err != nil {
+			return false, HookError{Hook: HookSystemTask, Transition: transitionIndex, TokenID: tokenID, Err: err}
+		}
+		return true, nil
+	}
+	if h := e.handler(); h.OnSystemTask != nil {
+		return h.OnSystemTask(flow, tokenID, transitionIndex), nil
+	}
+	return false, HookError{Hook: HookSystemTask, Transition: transitionIndex, TokenID: tokenID, Err: ErrNotHandled}
+}
+
+// tells the system about a task which will not be completed, the result of a legacy hook is ignored
+func (e *Engine) systemTaskCancelled(ctx context.Context, flow *Flow, tokenID int, transitionIndex int) error {
+	if v2 := e.hooks().OnSystemTaskCancelled; v2 != nil {
+		if err := v2(ctx, flow, tokenID, transitionIndex); err != nil {
+			return HookError{Hook: HookSystemTaskCancelled, Transition: transitionIndex, TokenID: tokenID, Err: err}
+		}
+		return nil
+	}
+	if h := e.handler(); h.OnSystemTaskCancelled != nil {
+		h.OnSystemTaskCancelled(flow, tokenID, transitionIndex)
+	}
+	return nil
+}
+
+// notifies a state change
+func (e *Engine) stateChanged(ctx context.Context, flow *Flow) error {
+	if v2 := e.hooks().OnStateChanged; v2 != nil {
+		if err := v2(ctx, flow); err != nil {
+			return HookError{Hook: HookStateChanged, Transition: -1, Err: err}
+		}
+		return nil
+	}
+	if h := e.handler(); h.OnStateChanged != nil {
+		h.OnStateChanged(flow)
+	}
+	return nil
+}
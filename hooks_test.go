@@ -0,0 +1,141 @@
+package bpnet_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// start -> split (auto) -> p1 + p2, p1 -> task -> p3, p2 -> review (user) -> p4
+// test/hooks.yaml: start -> split (auto) -> p1 + p2, p1 -> task -> p3, p2 -> review (user) -> p4
+func hookProcess(t *testing.T, taskType string) bpnet.Process {
+	return buildfile(t, "test/hooks.yaml", func(net *bpnet.ImportNet) {
+		net.Transition[1].TransitionType = taskType
+	})
+}
+
+type ctxKey struct{}
+
+func TestHooks_SendMessageError(t *testing.T) {
+	failure := errors.New("broker down")
+	e := bpnet.NewEngine(nil)
+	e.Hooks = &bpnet.HandlerV2{
+		OnSendMessage: func(ctx context.Context, flow *bpnet.Flow, transitionIndex int) error {
+			return failure
+		},
+	}
+
+	f := e.CreateFlow(hookProcess(t, "message"), "veith")
+	err := f.Start(nil)
+	var hookErr bpnet.HookError
+	if !errors.As(err, &hookErr) || hookErr.Hook != bpnet.HookSendMessage || !errors.Is(err, failure) {
+		t.Fatal("should return the error of OnSendMessage, got", err)
+	}
+	if f.Net.State[1] != 1 || f.Net.State[3] != 0 {
+		t.Error("message transition should not fire", f.Net.State)
+	}
+}
+
+func TestHooks_LegacySendMessageFalse(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	f := e.CreateFlow(hookProcess(t, "message"), "veith")
+	err := f.Start(nil)
+	if !errors.Is(err, bpnet.ErrNotHandled) {
+		t.Error("missing OnSendMessage should return ErrNotHandled instead of a panic, got", err)
+	}
+}
+
+func TestHooks_SystemTaskRetry(t *testing.T) {
+	available := false
+	e := bpnet.NewEngine(nil)
+	e.Hooks = &bpnet.HandlerV2{
+		OnSystemTask: func(ctx context.Context, flow *bpnet.Flow, tokenID int, transitionIndex int) error {
+			if !available {
+				return errors.New("queue full")
+			}
+			return nil
+		},
+	}
+
+	f := e.CreateFlow(hookProcess(t, "system"), "veith")
+	if err := f.Start(nil); err == nil {
+		t.Fatal("should return the error of OnSystemTask")
+	}
+	if len(f.TransitionsInProgress) != 0 {
+		t.Error("failed system task should leave the token parked", f.TransitionsInProgress)
+	}
+
+	available = true
+	if err := f.Fire(2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.TransitionsInProgress) != 1 {
+		t.Error("system task should be dispatched again with the next state change", f.TransitionsInProgress)
+	}
+}
+
+func TestHooks_NotificationErrorKeepsState(t *testing.T) {
+	failure := errors.New("audit failed")
+	var seen interface{}
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool { return true },
+	})
+	e.Hooks = &bpnet.HandlerV2{
+		OnFireCompleted: func(ctx context.Context, flow *bpnet.Flow, transitionIndex int) error {
+			seen = ctx.Value(ctxKey{})
+			return failure
+		},
+	}
+
+	f := e.CreateFlow(hookProcess(t, "system"), "veith")
+	if err := f.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request-1")
+	err := f.FireContext(ctx, 2, nil)
+	if !errors.Is(err, failure) {
+		t.Error("should return the error of OnFireCompleted, got", err)
+	}
+	if f.Net.State[4] != 1 {
+		t.Error("transition should stay fired", f.Net.State)
+	}
+	if seen != "request-1" {
+		t.Error("hook should receive the context of the caller, got", seen)
+	}
+}
+
+func TestHooks_FlowHooksWithoutTransition(t *testing.T) {
+	transitions := make(map[string]int)
+	flowHook := func(name string) bpnet.NotifyV2 {
+		return func(ctx context.Context, flow *bpnet.Flow, transitionIndex int) error {
+			transitions[name] = transitionIndex
+			return nil
+		}
+	}
+	e := bpnet.NewEngine(nil)
+	e.Hooks = &bpnet.HandlerV2{
+		OnProcessStarted:    flowHook("started"),
+		OnProcessCompleted:  flowHook("completed"),
+		OnProcessCancelled:  flowHook("cancelled"),
+		OnSuspensionChanged: flowHook("suspension"),
+	}
+
+	f := e.CreateFlow(hookProcess(t, "auto"), "veith")
+	f.Start(nil)
+	f.Suspend()
+	f.Resume()
+	if err := f.Fire(2, nil); err != nil {
+		t.Fatal(err)
+	}
+	cancelled := e.CreateFlow(hookProcess(t, "auto"), "veith")
+	cancelled.Start(nil)
+	cancelled.Cancel("stop")
+
+	for _, name := range []string{"started", "completed", "cancelled", "suspension"} {
+		if transition, ok := transitions[name]; !ok || transition != -1 {
+			t.Error("hook of the flow should get transition -1:", name, transition, ok)
+		}
+	}
+}
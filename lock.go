@@ -0,0 +1,52 @@
+package bpnet
+
+import (
+	"context"
+	"sync"
+)
+
+// guards the lazy creation of the flow locks
+var flowLockGuard sync.Mutex
+
+// petrinet counts token ids in a package variable, fires of all flows go through this lock.
+// The ids of the flow come from Flow.TokenCounter, see tokens.go.
+var netMu sync.Mutex
+
+type heldLocksKey struct{}
+
+// View calls fn while no start, fire, timer or system task of the flow is running.
+// Use it to read the state of a flow which is driven by timers. fn must not call
+// methods of the flow, hooks are called with the lock already held.
+func (f *Flow) View(fn func(flow *Flow)) {
+	mu := f.mutex()
+	mu.Lock()
+	defer mu.Unlock()
+	fn(f)
+}
+
+// the lock is shared by all copies of a flow, flows which were not created by an engine get one on first use
+func (f *Flow) mutex() *sync.Mutex {
+	flowLockGuard.Lock()
+	defer flowLockGuard.Unlock()
+	if f.mu == nil {
+		f.mu = new(sync.Mutex)
+	}
+	return f.mu
+}
+
+// locks the flow for a call chain. The locks held are passed in the context, so a subflow
+// which completes inside the start of its parent can fire the parent without a deadlock.
+func (f *Flow) lock(ctx context.Context) (context.Context, func()) {
+	mu := f.mutex()
+	held, _ := ctx.Value(heldLocksKey{}).(map[*sync.Mutex]bool)
+	if held[mu] {
+		return ctx, func() {}
+	}
+	mu.Lock()
+	next := make(map[*sync.Mutex]bool, len(held)+1)
+	for m := range held {
+		next[m] = true
+	}
+	next[mu] = true
+	return context.WithValue(ctx, heldLocksKey{}, next), mu.Unlock
+}
@@ -0,0 +1,64 @@
+package bpnet_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veith/bpnet"
+)
+
+// test/concurrent.yaml with n tokens: p0 -> wait (timed) -> p1 -> take (user) -> p2
+func concurrentProcess(t *testing.T, tokens int) bpnet.Process {
+	return buildfile(t, "test/concurrent.yaml", func(net *bpnet.ImportNet) {
+		net.Place[0].Tokens = tokens
+	})
+}
+
+func TestFlow_ConcurrentTimersAndFires(t *testing.T) {
+	const tokens = 20
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.History = sink
+
+	flows := []bpnet.Flow{
+		e.CreateFlow(concurrentProcess(t, tokens), "veith"),
+		e.CreateFlow(concurrentProcess(t, tokens), "veith"),
+	}
+	for i := range flows {
+		if err := flows[i].Start(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var wg sync.WaitGroup
+	for i := range flows {
+		f := &flows[i]
+		for w := 0; w < tokens; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// feuern, sobald ein timer einen token freigegeben hat
+				for f.Fire(1, nil) != nil {
+					if time.Now().After(deadline) {
+						return
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i := range flows {
+		flows[i].View(func(f *bpnet.Flow) {
+			if f.Net.State[2] != tokens {
+				t.Error("all tokens should be taken, state is", f.Net.State)
+			}
+			if err := bpnet.VerifyHistory(f, sink.Events(f.ID)); err != nil {
+				t.Error("history should match the flow", err)
+			}
+		})
+	}
+}
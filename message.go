@@ -0,0 +1,368 @@
+package bpnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/oklog/ulid"
+)
+
+// MessageSubscription is a token of a RECEIVE transition waiting for a message.
+// The transition details name the message and the correlation keys:
+//
+//	details:
+//	  message: payment_received
+//	  correlation:
+//	    order: orderId          # expression over the flow variables
+//
+// A message matches when it carries every key of the subscription with the same value.
+type MessageSubscription struct {
+	FlowID  ulid.ULID         `json:"flow"`
+	TokenID int               `json:"token"`
+	Message string            `json:"message"`
+	Keys    map[string]string `json:"keys,omitempty"` // evaluated correlation keys
+}
+
+// MessageIndex knows the tokens waiting for messages
+type MessageIndex interface {
+	// Subscribe registers a waiting token, a known token is replaced
+	Subscribe(sub MessageSubscription) error
+	Unsubscribe(flowID ulid.ULID, tokenID int) error
+	// Match returns the subscriptions for a message with the keys, oldest first
+	Match(message string, keys map[string]string) ([]MessageSubscription, error)
+}
+
+// MemoryMessageIndex is a MessageIndex in memory
+type MemoryMessageIndex struct {
+	mu   sync.Mutex
+	subs map[string][]MessageSubscription // [message]subscriptions in order of subscription
+}
+
+func NewMemoryMessageIndex() *MemoryMessageIndex {
+	return &MemoryMessageIndex{subs: make(map[string][]MessageSubscription)}
+}
+
+func (i *MemoryMessageIndex) Subscribe(sub MessageSubscription) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.remove(sub.FlowID, sub.TokenID)
+	i.subs[sub.Message] = append(i.subs[sub.Message], sub)
+	return nil
+}
+
+func (i *MemoryMessageIndex) Unsubscribe(flowID ulid.ULID, tokenID int) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.remove(flowID, tokenID)
+	return nil
+}
+
+func (i *MemoryMessageIndex) Match(message string, keys map[string]string) ([]MessageSubscription, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var matches []MessageSubscription
+	for _, sub := range i.subs[message] {
+		if keysMatch(sub.Keys, keys) {
+			matches = append(matches, sub)
+		}
+	}
+	return matches, nil
+}
+
+func (i *MemoryMessageIndex) remove(flowID ulid.ULID, tokenID int) {
+	for message, subs := range i.subs {
+		for index, sub := range subs {
+			if sub.FlowID == flowID && sub.TokenID == tokenID {
+				i.subs[message] = append(subs[:index:index], subs[index+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// the message carries every key of the subscription
+func keysMatch(subscription map[string]string, message map[string]string) bool {
+	for key, value := range subscription {
+		if v, ok := message[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// BufferPolicy keeps messages which arrive before a flow waits for them or while it is suspended.
+// Buffered messages are kept in memory only.
+type BufferPolicy struct {
+	TTL   time.Duration // how long a message waits, 0 drops messages nobody waits for
+	Limit int           // messages kept per message name, the oldest goes first; 0 for no limit
+}
+
+type bufferedMessage struct {
+	FlowID  ulid.ULID // suspended flow the message is held for, zero for any flow
+	Name    string
+	Keys    map[string]string
+	Payload map[string]interface{}
+	Expires time.Time
+}
+
+// CorrelationError is returned by Correlate for a message which no flow waits for and which is not buffered
+type CorrelationError struct {
+	Message string
+	Keys    map[string]string
+}
+
+func (e CorrelationError) Error() string {
+	return fmt.Sprintf("no flow waits for message %s with keys %v", e.Message, e.Keys)
+}
+
+// Correlate delivers a message, see CorrelateContext
+func (e *Engine) Correlate(messageName string, correlationKeys map[string]interface{}, payload map[string]interface{}) error {
+	return e.CorrelateContext(context.Background(), messageName, correlationKeys, payload)
+}
+
+// CorrelateContext delivers a message to the oldest token waiting for it with matching keys.
+// The payload is taken over into the variables of the flow and the RECEIVE transition fires.
+// Without a waiting token the message is buffered according to MessageBuffer, otherwise a
+// CorrelationError is returned. A message for a suspended flow is held for this flow according
+// to MessageBuffer until it is resumed, without buffering ErrFlowSuspended is returned.
+// The flows are loaded via FlowInstanceLoader.
+func (e *Engine) CorrelateContext(ctx context.Context, messageName string, correlationKeys map[string]interface{}, payload map[string]interface{}) error {
+	keys := make(map[string]string, len(correlationKeys))
+	for key, value := range correlationKeys {
+		keys[key] = correlationValue(value)
+	}
+	subs, err := e.messageIndex().Match(messageName, keys)
+	if err != nil {
+		return err
+	}
+	var suspended ulid.ULID
+	for _, sub := range subs {
+		flow, loadErr := e.loadFlow(ctx, sub.FlowID)
+		if loadErr != nil || flow == nil {
+			err = firstError(err, HookError{Hook: HookFlowInstanceLoader, Transition: -1, TokenID: sub.TokenID, Err: loadErr})
+			continue
+		}
+		delivered, receiveErr := flow.receive(ctx, sub.TokenID, messageName, payload)
+		if errors.Is(receiveErr, ErrFlowSuspended) {
+			if suspended == (ulid.ULID{}) {
+				suspended = sub.FlowID
+			}
+			continue
+		}
+		if delivered || receiveErr != nil {
+			return receiveErr
+		}
+	}
+	if suspended != (ulid.ULID{}) {
+		// der flow wartet, Resume holt die nachricht ab
+		if e.bufferMessage(suspended, messageName, keys, payload) {
+			return nil
+		}
+		return ErrFlowSuspended
+	}
+	if e.bufferMessage(ulid.ULID{}, messageName, keys, payload) {
+		return nil
+	}
+	return firstError(err, CorrelationError{Message: messageName, Keys: keys})
+}
+
+// json numbers and go ints of the same value give the same key
+func correlationValue(value interface{}) string {
+	return fmt.Sprint(value)
+}
+
+// takes a message over and fires the RECEIVE transition of the token. A token which
+// does not wait anymore does not take the message, a suspended flow returns ErrFlowSuspended.
+func (f *Flow) receive(ctx context.Context, tokenID int, message string, payload map[string]interface{}) (bool, error) {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	sub, waiting := f.Subscriptions[tokenID]
+	if !waiting || sub.Message != message {
+		f.Engine().messageIndex().Unsubscribe(f.ID, tokenID)
+		return false, nil
+	}
+	if f.checkActive() != nil {
+		return false, nil
+	}
+	if f.Suspended {
+		return false, ErrFlowSuspended
+	}
+	transition := f.TransitionsInProgress[tokenID]
+	changes, err := f.appendData(payload, f.transitionID(transition))
+	if err != nil {
+		return false, err
+	}
+	f.unsubscribe(tokenID)
+	_, err = f.fireWithTokenId(ctx, tokenID, Event{Type: EventMessageReceived, TokenID: tokenID, Actor: actorID(ctx, ActorSystem), Changes: changes, Message: message})
+	return true, err
+}
+
+// registers a token of a RECEIVE transition in the MessageIndex
+func (f *Flow) subscribe(transition int, tokenID int) error {
+	details := f.Process.Transitions[transition].Details
+	message, _ := details["message"].(string)
+	keys, err := correlationKeys(details["correlation"], f.Net.Variables)
+	if err != nil {
+		return err
+	}
+	sub := MessageSubscription{FlowID: f.ID, TokenID: tokenID, Message: message, Keys: keys}
+	if err := f.Engine().messageIndex().Subscribe(sub); err != nil {
+		return err
+	}
+	if f.Subscriptions == nil {
+		f.Subscriptions = make(map[int]MessageSubscription)
+	}
+	f.Subscriptions[tokenID] = sub
+	f.TransitionsInProgress[tokenID] = transition
+	f.record(Event{Type: EventMessageAwaited, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: ActorEngine, Message: message, Correlation: keys})
+	return nil
+}
+
+func (f *Flow) unsubscribe(tokenID int) {
+	delete(f.Subscriptions, tokenID)
+	f.Engine().messageIndex().Unsubscribe(f.ID, tokenID)
+}
+
+// delivers buffered messages to the waiting tokens, oldest token first
+func (f *Flow) receiveBuffered(ctx context.Context) error {
+	tokens := make([]int, 0, len(f.Subscriptions))
+	for tokenID := range f.Subscriptions {
+		tokens = append(tokens, tokenID)
+	}
+	sort.Ints(tokens)
+	var err error
+	for _, tokenID := range tokens {
+		sub, waiting := f.Subscriptions[tokenID]
+		if !waiting {
+			continue
+		}
+		if message, ok := f.Engine().takeBuffered(sub); ok {
+			_, receiveErr := f.receive(ctx, tokenID, message.Name, message.Payload)
+			err = firstError(err, receiveErr)
+		}
+	}
+	return err
+}
+
+// evaluates the correlation expressions of a transition
+func correlationKeys(correlation interface{}, variables map[string]interface{}) (map[string]string, error) {
+	if correlation == nil {
+		return nil, nil
+	}
+	expressions, ok := correlation.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("correlation of type %T is not supported", correlation)
+	}
+	keys := make(map[string]string, len(expressions))
+	for key, expression := range expressions {
+		source, ok := expression.(string)
+		if !ok {
+			return nil, fmt.Errorf("correlation key %s is not an expression", key)
+		}
+		value, err := expr.Eval(strings.TrimPrefix(strings.TrimSpace(source), "="), variables)
+		if err != nil {
+			return nil, fmt.Errorf("correlation key %s: %v", key, err)
+		}
+		keys[key] = correlationValue(value)
+	}
+	return keys, nil
+}
+
+// keeps a message nobody waits for or which is held for a suspended flow,
+// false when the policy does not buffer
+func (e *Engine) bufferMessage(flowID ulid.ULID, name string, keys map[string]string, payload map[string]interface{}) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	policy := e.MessageBuffer
+	if policy.TTL <= 0 {
+		return false
+	}
+	now := e.clock().Now()
+	e.buffered = append(e.liveMessages(now), bufferedMessage{FlowID: flowID, Name: name, Keys: keys, Payload: payload, Expires: now.Add(policy.TTL)})
+	if policy.Limit > 0 {
+		count := 0
+		for i := len(e.buffered) - 1; i >= 0; i-- {
+			if e.buffered[i].Name != name {
+				continue
+			}
+			if count++; count > policy.Limit {
+				e.buffered = append(e.buffered[:i:i], e.buffered[i+1:]...)
+			}
+		}
+	}
+	return true
+}
+
+// removes and returns the oldest buffered message for a subscription
+func (e *Engine) takeBuffered(sub MessageSubscription) (bufferedMessage, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.buffered = e.liveMessages(e.clock().Now())
+	for i, message := range e.buffered {
+		if message.Name == sub.Message && keysMatch(sub.Keys, message.Keys) && (message.FlowID == ulid.ULID{} || message.FlowID == sub.FlowID) {
+			e.buffered = append(e.buffered[:i:i], e.buffered[i+1:]...)
+			return message, true
+		}
+	}
+	return bufferedMessage{}, false
+}
+
+// buffered messages which have not expired, e.mu is held
+func (e *Engine) liveMessages(now time.Time) []bufferedMessage {
+	live := e.buffered[:0:0]
+	for _, message := range e.buffered {
+		if message.Expires.After(now) {
+			live = append(live, message)
+		}
+	}
+	return live
+}
+
+// drops the messages held for a flow
+func (e *Engine) dropHeldMessages(flowID ulid.ULID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	kept := e.buffered[:0:0]
+	for _, message := range e.buffered {
+		if message.FlowID != flowID {
+			kept = append(kept, message)
+		}
+	}
+	e.buffered = kept
+}
+
+func (e *Engine) messageIndex() MessageIndex {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.Messages == nil {
+		e.Messages = NewMemoryMessageIndex()
+	}
+	return e.Messages
+}
+
+// checks the correlation of a RECEIVE transition when the process is built, expressions are only compiled
+func validateCorrelation(correlation interface{}) error {
+	if correlation == nil {
+		return nil
+	}
+	expressions, ok := correlation.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("correlation of type %T is not supported", correlation)
+	}
+	for key, expression := range expressions {
+		source, ok := expression.(string)
+		if !ok {
+			return fmt.Errorf("correlation key %s is not an expression", key)
+		}
+		if _, err := expr.Compile(strings.TrimPrefix(strings.TrimSpace(source), "=")); err != nil {
+			return fmt.Errorf("correlation key %s: %v", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package bpnet_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/veith/bpnet"
+)
+
+func TestCorrelate_MatchingFlow(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.History = sink
+	a := e.CreateFlow(buildfile(t, "test/payment.yaml"), "veith")
+	b := e.CreateFlow(buildfile(t, "test/payment.yaml"), "veith")
+	a.Start(map[string]interface{}{"orderId": "A-1"})
+	b.Start(map[string]interface{}{"orderId": "B-2"})
+	if len(b.Subscriptions) != 1 || b.Net.State[0] != 1 {
+		t.Fatal("token should wait for the message", b.Subscriptions, b.Net.State)
+	}
+
+	err := e.Correlate("payment_received", map[string]interface{}{"order": "B-2"}, map[string]interface{}{"amount": 12.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Net.State[1] != 1 || b.Net.Variables["amount"] != 12.5 {
+		t.Error("flow B should receive the message", b.Net.State, b.Net.Variables)
+	}
+	if a.Net.State[1] != 0 {
+		t.Error("flow A should still wait", a.Net.State)
+	}
+	if err := bpnet.VerifyHistory(&b, sink.Events(b.ID)); err != nil {
+		t.Error("history should match the flow", err)
+	}
+
+	var correlationErr bpnet.CorrelationError
+	err = e.Correlate("payment_received", map[string]interface{}{"order": "C-3"}, nil)
+	if !errors.As(err, &correlationErr) {
+		t.Error("message without waiting flow should not be correlated, got", err)
+	}
+}
+
+func TestCorrelate_Buffer(t *testing.T) {
+	clock := newManualClock()
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.Clock = clock
+	e.MessageBuffer = bpnet.BufferPolicy{TTL: time.Minute}
+
+	if err := e.Correlate("payment_received", map[string]interface{}{"order": "A-1"}, map[string]interface{}{"amount": 3}); err != nil {
+		t.Fatal("message should be buffered, got", err)
+	}
+	f := e.CreateFlow(buildfile(t, "test/payment.yaml"), "veith")
+	f.Start(map[string]interface{}{"orderId": "A-1"})
+	if f.Net.State[1] != 1 || f.Net.Variables["amount"] != 3.0 {
+		t.Error("buffered message should be delivered when the flow waits", f.Net.State, f.Net.Variables)
+	}
+
+	e.Correlate("payment_received", map[string]interface{}{"order": "B-2"}, nil)
+	clock.Advance(2 * time.Minute)
+	late := e.CreateFlow(buildfile(t, "test/payment.yaml"), "veith")
+	late.Start(map[string]interface{}{"orderId": "B-2"})
+	if late.Net.State[1] != 0 {
+		t.Error("expired message should not be delivered", late.Net.State)
+	}
+}
+
+func TestCorrelate_SuspendedFlow(t *testing.T) {
+	clock := newManualClock()
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.Clock = clock
+	f := e.CreateFlow(buildfile(t, "test/payment.yaml"), "veith")
+	f.Start(map[string]interface{}{"orderId": "A-1"})
+	f.Suspend()
+
+	err := e.Correlate("payment_received", map[string]interface{}{"order": "A-1"}, map[string]interface{}{"amount": 3})
+	if !errors.Is(err, bpnet.ErrFlowSuspended) {
+		t.Error("message for a suspended flow should be refused without buffer, got", err)
+	}
+
+	e.MessageBuffer = bpnet.BufferPolicy{TTL: 2 * time.Hour}
+	if err := e.Correlate("payment_received", map[string]interface{}{"order": "A-1"}, map[string]interface{}{"amount": 3}); err != nil {
+		t.Fatal("message for a suspended flow should be held, got", err)
+	}
+	if f.Net.State[1] != 0 {
+		t.Error("suspended flow should not receive the message", f.Net.State)
+	}
+	other := e.CreateFlow(buildfile(t, "test/payment.yaml"), "veith")
+	other.Start(map[string]interface{}{"orderId": "A-1"})
+	if other.Net.State[1] != 0 {
+		t.Error("held message should only go to its flow", other.Net.State)
+	}
+	clock.Advance(time.Hour)
+	if err := f.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[1] != 1 || f.Net.Variables["amount"] != 3.0 {
+		t.Error("held message should be delivered on resume", f.Net.State, f.Net.Variables)
+	}
+}
+
+func TestCorrelate_SuspendedFlowExpires(t *testing.T) {
+	clock := newManualClock()
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.Clock = clock
+	e.MessageBuffer = bpnet.BufferPolicy{TTL: 30 * time.Minute}
+	f := e.CreateFlow(buildfile(t, "test/payment.yaml"), "veith")
+	f.Start(map[string]interface{}{"orderId": "A-1"})
+	f.Suspend()
+
+	if err := e.Correlate("payment_received", map[string]interface{}{"order": "A-1"}, map[string]interface{}{"amount": 3}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if err := f.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[1] != 0 {
+		t.Error("held message should expire with the buffer TTL", f.Net.State)
+	}
+}
@@ -0,0 +1,252 @@
+package bpnet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antonmedv/expr"
+	"github.com/oklog/ulid"
+)
+
+// MigrationPlan tells how the tokens of a flow move to another version of its process
+type MigrationPlan struct {
+	Places      map[string]string // old place id -> new place id, places which are not listed keep their id
+	Transitions map[string]string // old transition id -> new transition id for tokens in progress, same default
+	DryRun      bool              // only report, the flows are not changed
+}
+
+// MovedToken is a token and its place in the new version
+type MovedToken struct {
+	TokenID int    `json:"token"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// MigrationIssue is a token which can not be moved or a condition of the target which does not fit the flow
+type MigrationIssue struct {
+	TokenID    int    `json:"token"`                // 0 for a condition
+	Place      string `json:"place"`                // place of the token in the old version
+	Transition string `json:"transition,omitempty"` // transition in progress of the token
+	Reason     string `json:"reason"`
+}
+
+// MigrationReport is the outcome of the migration of one flow
+type MigrationReport struct {
+	FlowID      ulid.ULID        `json:"flow"`
+	FromVersion string           `json:"from_version"`
+	ToVersion   string           `json:"to_version"`
+	Moved       []MovedToken     `json:"moved"`
+	Issues      []MigrationIssue `json:"issues"`
+	Migrated    bool             `json:"migrated"` // false for a dry run or a flow with issues
+}
+
+// MigrationError lists the tokens of a flow which can not be migrated
+type MigrationError struct {
+	FlowID ulid.ULID
+	Issues []MigrationIssue
+}
+
+func (e MigrationError) Len() int {
+	return len(e.Issues)
+}
+
+func (e MigrationError) Error() string {
+	reasons := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		if issue.TokenID == 0 {
+			reasons[i] = fmt.Sprintf("transition %s: %s", issue.Transition, issue.Reason)
+			continue
+		}
+		reasons[i] = fmt.Sprintf("token %d on %s: %s", issue.TokenID, issue.Place, issue.Reason)
+	}
+	return "flow " + e.FlowID.String() + " can not be migrated: " + strings.Join(reasons, "; ")
+}
+
+// Migrate moves a running flow to the target version of its process, cancelled and completed
+// flows are refused. The marking, the token ids and the transitions in progress are kept, armed
+// timers expire at their due time. Nothing is changed when a token has no place or transition in
+// the target or a condition of the target uses a variable the flow has not set, the issues are
+// returned as MigrationError. A migrated event is recorded, see ReplayVersions.
+func (f *Flow) Migrate(ctx context.Context, target Process, plan MigrationPlan) (MigrationReport, error) {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+
+	report := MigrationReport{FlowID: f.ID, FromVersion: f.ProcessVersion, ToVersion: target.Version}
+	if err := f.checkRunning(); err != nil {
+		return report, err
+	}
+	if target.Name != f.ProcessName {
+		return report, fmt.Errorf("flow of process %q can not be migrated to process %q", f.ProcessName, target.Name)
+	}
+	if len(f.Process.Places) != len(f.Net.TokenIds) || len(target.Places) != len(target.InitialState) {
+		return report, fmt.Errorf("process %q has no place ids to migrate with", f.ProcessName)
+	}
+
+	places := make(map[string]int)
+	for index, id := range target.Places {
+		places[id] = index
+	}
+	transitions := make(map[string]int)
+	for index, transition := range target.Transitions {
+		transitions[transition.ID] = index
+	}
+
+	// tokens auf die neuen stellen verteilen
+	tokenIds := make([][]int, len(target.Places))
+	newPlace := make(map[int]int)
+	oldPlace := make(map[int]string)
+	for place, ids := range f.Net.TokenIds {
+		from := f.Process.Places[place]
+		to, ok := plan.Places[from]
+		if !ok {
+			to = from
+		}
+		index, exists := places[to]
+		for _, tokenID := range ids {
+			oldPlace[tokenID] = from
+			if !exists {
+				report.Issues = append(report.Issues, MigrationIssue{TokenID: tokenID, Place: from, Reason: fmt.Sprintf("place %q does not exist in version %s", to, target.Version)})
+				continue
+			}
+			tokenIds[index] = append(tokenIds[index], tokenID)
+			newPlace[tokenID] = index
+			report.Moved = append(report.Moved, MovedToken{TokenID: tokenID, From: from, To: to})
+		}
+	}
+
+	// laufende timer, systemtasks und subprozesse brauchen ein gegenstück
+	tokens := make([]int, 0, len(f.TransitionsInProgress))
+	for tokenID := range f.TransitionsInProgress {
+		tokens = append(tokens, tokenID)
+	}
+	sort.Ints(tokens)
+	inProgress := make(map[int]int)
+	for _, tokenID := range tokens {
+		transition := f.TransitionsInProgress[tokenID]
+		from := f.transitionID(transition)
+		to, ok := plan.Transitions[from]
+		if !ok {
+			to = from
+		}
+		issue := MigrationIssue{TokenID: tokenID, Place: oldPlace[tokenID], Transition: from}
+		index, exists := transitions[to]
+		place, placed := newPlace[tokenID]
+		switch {
+		case !exists:
+			issue.Reason = fmt.Sprintf("transition %q does not exist in version %s", to, target.Version)
+		case target.TransitionTypes[index] != f.Process.TransitionTypes[transition]:
+			issue.Reason = fmt.Sprintf("transition %q has another type in version %s", to, target.Version)
+		case placed && target.InputMatrix[index][place] == 0:
+			issue.Reason = fmt.Sprintf("transition %q does not take the token from place %q", to, target.Places[place])
+		default:
+			inProgress[tokenID] = index
+			continue
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	// zuweisungen von benutzeraufgaben folgen ihrer transition
+	assignees := make(map[int]string)
+	for transition, user := range f.Assignees {
+		from := f.transitionID(transition)
+		to, ok := plan.Transitions[from]
+		if !ok {
+			to = from
+		}
+		if index, exists := transitions[to]; exists {
+			assignees[index] = user
+		}
+	}
+
+	// der net kompiliert die bedingungen mit den variablen des flows und bricht sonst ab
+	for transition, conditions := range target.ConditionMatrix {
+		for _, condition := range conditions {
+			if _, err := expr.Compile(condition, expr.Env(f.Net.Variables)); err != nil {
+				id := ""
+				if transition < len(target.Transitions) {
+					id = target.Transitions[transition].ID
+				}
+				report.Issues = append(report.Issues, MigrationIssue{Transition: id, Reason: fmt.Sprintf("condition %q does not fit the variables: %v", condition, err)})
+			}
+		}
+	}
+
+	if len(report.Issues) > 0 {
+		return report, MigrationError{FlowID: f.ID, Issues: report.Issues}
+	}
+	if plan.DryRun {
+		return report, nil
+	}
+
+	var consumed, produced []PlacedToken
+	for place, ids := range f.Net.TokenIds {
+		for _, tokenID := range ids {
+			consumed = append(consumed, PlacedToken{Place: place, TokenID: tokenID})
+		}
+	}
+	for place, ids := range tokenIds {
+		for _, tokenID := range ids {
+			produced = append(produced, PlacedToken{Place: place, TokenID: tokenID})
+		}
+	}
+
+	f.Process = target
+	f.ProcessVersion = target.Version
+	f.Net.InputMatrix = target.InputMatrix
+	f.Net.OutputMatrix = target.OutputMatrix
+	f.Net.ConditionMatrix = target.ConditionMatrix
+	f.Net.State = make([]int, len(tokenIds))
+	for place, ids := range tokenIds {
+		f.Net.State[place] = len(ids)
+	}
+	f.Net.TokenIds = tokenIds
+	f.TransitionsInProgress = inProgress
+	f.Assignees = assignees
+	// incidents gehören zu systemtasks in progress, der index kommt aus inProgress
+	if f.Incidents != nil {
+		incidents := make([]Incident, len(f.Incidents))
+		for i, incident := range f.Incidents {
+			incident.Transition = inProgress[incident.TokenID]
+			incident.TransitionID = target.Transitions[incident.Transition].ID
+			incidents[i] = incident
+		}
+		f.Incidents = incidents
+	}
+	f.rebuildNet()
+	f.record(Event{Type: EventMigrated, Actor: actorID(ctx, f.Owner), FromVersion: report.FromVersion, ToVersion: report.ToVersion, Renamed: plan.Transitions, Consumed: consumed, Produced: produced})
+
+	// der timer store kennt den index der transition
+	e := f.Engine()
+	var storeErr error
+	for tokenID, due := range f.TimersDue {
+		storeErr = firstError(storeErr, e.timerStore().Schedule(ScheduledTimer{FlowID: f.ID, TokenID: tokenID, Transition: inProgress[tokenID], Due: due}))
+	}
+	report.Migrated = true
+
+	// neu aktivierte transitionen der neuen version auslösen
+	var err error
+	f.AvailableUserTransitions, err = f.bpnTransitionsCheck(ctx)
+	return report, firstError(storeErr, err)
+}
+
+// Migrate moves the running flows of the engine which use another version of the target process.
+// With plan.DryRun the reports list the affected flows without changing them.
+func (e *Engine) Migrate(ctx context.Context, target Process, plan MigrationPlan) ([]MigrationReport, error) {
+	var reports []MigrationReport
+	var err error
+	for _, flow := range e.Flows() {
+		affected := false
+		flow.View(func(flow *Flow) {
+			affected = flow.ProcessName == target.Name && flow.ProcessVersion != target.Version
+		})
+		if !affected {
+			continue
+		}
+		report, migrateErr := flow.Migrate(ctx, target, plan)
+		reports = append(reports, report)
+		err = firstError(err, migrateErr)
+	}
+	return reports, err
+}
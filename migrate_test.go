@@ -0,0 +1,220 @@
+package bpnet_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// version 2 of test/migration.yaml with a renamed place or transition.
+// start -> split (auto) -> queued + review, queued -> check (system) -> checked, review -> approve (user) -> done
+func renamed(from string, to string) func(net *bpnet.ImportNet) {
+	return func(net *bpnet.ImportNet) {
+		net.Version = "2"
+		for i := range net.Place {
+			if net.Place[i].ID == from {
+				net.Place[i].ID = to
+			}
+		}
+		for i := range net.Transition {
+			if net.Transition[i].ID == from {
+				net.Transition[i].ID = to
+			}
+		}
+		for i := range net.Arc {
+			if net.Arc[i].Source == from {
+				net.Arc[i].Source = to
+			}
+			if net.Arc[i].Destination == from {
+				net.Arc[i].Destination = to
+			}
+		}
+	}
+}
+
+func startMigrationFlow(t *testing.T) (*bpnet.Engine, *bpnet.Flow, *int) {
+	systemToken := new(int)
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool {
+			*systemToken = tokenID
+			return true
+		},
+	})
+	f := e.CreateFlow(buildfile(t, "test/migration.yaml"), "veith")
+	if err := f.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	return e, &f, systemToken
+}
+
+func TestMigrate_MovesTokens(t *testing.T) {
+	_, f, systemToken := startMigrationFlow(t)
+	target := buildfile(t, "test/migration.yaml", renamed("review", "reviewing"))
+
+	plan := bpnet.MigrationPlan{Places: map[string]string{"review": "reviewing"}}
+	report, err := f.Migrate(context.Background(), target, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Migrated || len(report.Moved) != 2 || f.ProcessVersion != "2" {
+		t.Error("flow should be migrated", report)
+	}
+	if f.TransitionsInProgress[*systemToken] != 1 {
+		t.Error("system task should stay in progress", f.TransitionsInProgress)
+	}
+
+	if err := f.Fire(2, nil); err != nil {
+		t.Error("user task should be enabled on the new place", err)
+	}
+	if err := f.FireSystemTask(*systemToken, nil); err != nil {
+		t.Error("system task should complete on the new version", err)
+	}
+	if f.Net.State[2] != 1 || f.Net.State[4] != 1 {
+		t.Error("migrated flow should run to the end", f.Net.State)
+	}
+}
+
+func TestMigrate_RemovedPlace(t *testing.T) {
+	_, f, _ := startMigrationFlow(t)
+	before := append([]int{}, f.Net.State...)
+
+	_, err := f.Migrate(context.Background(), buildfile(t, "test/migration.yaml", renamed("review", "reviewing")), bpnet.MigrationPlan{})
+	var migrationErr bpnet.MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.Len() != 1 || migrationErr.Issues[0].Place != "review" {
+		t.Fatal("token on a removed place should be reported, got", err)
+	}
+	if f.ProcessVersion != "1" || f.Net.State[3] != before[3] {
+		t.Error("flow should stay unchanged", f.Net.State)
+	}
+}
+
+func TestMigrate_TransitionInProgress(t *testing.T) {
+	_, f, systemToken := startMigrationFlow(t)
+
+	_, err := f.Migrate(context.Background(), buildfile(t, "test/migration.yaml", renamed("check", "verify")), bpnet.MigrationPlan{})
+	var migrationErr bpnet.MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.Len() != 1 {
+		t.Fatal("system task without counterpart should be reported, got", err)
+	}
+	if issue := migrationErr.Issues[0]; issue.TokenID != *systemToken || issue.Transition != "check" || issue.Place != "queued" || !strings.Contains(issue.Reason, "does not exist") {
+		t.Error("issue should name the token and the transition", issue)
+	}
+}
+
+func TestMigrate_DryRun(t *testing.T) {
+	e, f, _ := startMigrationFlow(t)
+
+	plan := bpnet.MigrationPlan{Places: map[string]string{"review": "reviewing"}, DryRun: true}
+	reports, err := e.Migrate(context.Background(), buildfile(t, "test/migration.yaml", renamed("review", "reviewing")), plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].FlowID != f.ID || reports[0].Migrated {
+		t.Error("dry run should list the affected flow", reports)
+	}
+	if f.ProcessVersion != "1" || f.Net.State[3] != 1 {
+		t.Error("dry run should not change the flow", f.ProcessVersion, f.Net.State)
+	}
+}
+
+func TestMigrate_Incident(t *testing.T) {
+	_, f, systemToken := startMigrationFlow(t)
+	if err := f.FailSystemTask(*systemToken, errors.New("down")); err != nil {
+		t.Fatal(err)
+	}
+	// in version 2 steht check hinter approve
+	target := buildfile(t, "test/migration.yaml", func(net *bpnet.ImportNet) {
+		net.Version = "2"
+		net.Transition[1], net.Transition[2] = net.Transition[2], net.Transition[1]
+	})
+	if _, err := f.Migrate(context.Background(), target, bpnet.MigrationPlan{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Incidents) != 1 || f.Incidents[0].Transition != 2 || f.Incidents[0].TransitionID != "check" {
+		t.Error("incident should follow its transition", f.Incidents)
+	}
+	if err := f.FireSystemTask(*systemToken, nil); err != nil || f.Incidents != nil {
+		t.Error("incident should be resolved on the new version", err, f.Incidents)
+	}
+}
+
+func TestMigrate_History(t *testing.T) {
+	registry := bpnet.NewProcessRegistry()
+	v1 := buildfile(t, "test/migration.yaml")
+	v2 := buildfile(t, "test/migration.yaml", renamed("review", "reviewing"), renamed("check", "verify"))
+	registry.Register(v1)
+	registry.Register(v2)
+
+	sink := &bpnet.MemorySink{}
+	systemToken := 0
+	e := bpnet.NewEngine(&bpnet.Handler{
+		ProcessDefinitionLoader: registry.Load,
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool {
+			systemToken = tokenID
+			return true
+		},
+	})
+	e.History = sink
+	f := e.CreateFlow(v1, "veith")
+	f.Start(nil)
+	f.Claim(2, bpnet.Actor{ID: "anna"})
+
+	plan := bpnet.MigrationPlan{Places: map[string]string{"review": "reviewing"}, Transitions: map[string]string{"check": "verify"}}
+	if _, err := f.Migrate(context.Background(), v2, plan); err != nil {
+		t.Fatal(err)
+	}
+	events := sink.Events(f.ID)
+	if migrated := events[len(events)-1]; migrated.Type != bpnet.EventMigrated || migrated.FromVersion != "1" || migrated.ToVersion != "2" || len(migrated.Produced) != 2 {
+		t.Fatal("migration should be recorded", migrated)
+	}
+	if err := bpnet.VerifyHistory(&f, events); err != nil {
+		t.Error("migrated flow should match its history", err)
+	}
+
+	f.FireSystemTask(systemToken, nil)
+	replayed, err := bpnet.ReplayVersions(v2, sink.Events(f.ID), registry.Load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ProcessVersion != "2" || replayed.Assignees[2] != "anna" || replayed.Net.State[2] != 1 {
+		t.Error("replay should continue with version 2", replayed.ProcessVersion, replayed.Assignees, replayed.Net.State)
+	}
+	if _, err := bpnet.Replay(v2, sink.Events(f.ID)); err == nil {
+		t.Error("replay without the old version should fail")
+	}
+}
+
+func TestMigrate_FinishedFlow(t *testing.T) {
+	_, f, _ := startMigrationFlow(t)
+	f.Cancel("stop")
+	_, err := f.Migrate(context.Background(), buildfile(t, "test/migration.yaml", renamed("review", "reviewing")), bpnet.MigrationPlan{})
+	if !errors.Is(err, bpnet.ErrFlowCancelled) {
+		t.Error("cancelled flow should not be migrated, got", err)
+	}
+	if f.ProcessVersion != "1" {
+		t.Error("cancelled flow should keep its version", f.ProcessVersion)
+	}
+}
+
+func TestMigrate_ConditionWithUnsetVariable(t *testing.T) {
+	_, f, _ := startMigrationFlow(t)
+	target := buildfile(t, "test/migration.yaml", renamed("review", "reviewing"), func(net *bpnet.ImportNet) {
+		net.Variables = append(net.Variables, bpnet.Variable{ID: "amount", Type: "int"})
+		for i := range net.Arc {
+			if net.Arc[i].Destination == "approve" {
+				net.Arc[i].Condition = "amount > 100"
+			}
+		}
+	})
+	report, err := f.Migrate(context.Background(), target, bpnet.MigrationPlan{Places: map[string]string{"review": "reviewing"}})
+	var migrationErr bpnet.MigrationError
+	if !errors.As(err, &migrationErr) || len(report.Issues) != 1 || report.Issues[0].Transition != "approve" {
+		t.Fatal("condition over an unset variable should be an issue, got", err, report.Issues)
+	}
+	if f.ProcessVersion != "1" {
+		t.Error("flow with issues should keep its version", f.ProcessVersion)
+	}
+}
@@ -0,0 +1,62 @@
+package bpnet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/antonmedv/expr"
+)
+
+// split modes of a transition with conditional output arcs, set in details.split
+const (
+	SplitOR  = "or"  // every tp arc whose condition holds produces tokens (default)
+	SplitXOR = "xor" // only the first tp arc in yaml order whose condition holds produces tokens
+)
+
+// ErrNoOutput rejects a fire when no condition of the output arcs holds
+var ErrNoOutput = errors.New("no condition of the output arcs holds")
+
+// OutputArc is a tp arc of a transition with conditional output, an empty condition always holds
+type OutputArc struct {
+	Place     int
+	Weight    int
+	Condition string
+}
+
+func (p Process) splitMode(transitionIndex int) string {
+	if transitionIndex < len(p.Transitions) {
+		if mode, _ := p.Transitions[transitionIndex].Details["split"].(string); mode != "" {
+			return mode
+		}
+	}
+	return SplitOR
+}
+
+// the output row of a transition for the current variables, nil for transitions without conditional output
+func (f *Flow) outputRow(transitionIndex int) ([]int, error) {
+	if transitionIndex >= len(f.Process.OutputArcs) || f.Process.OutputArcs[transitionIndex] == nil {
+		return nil, nil
+	}
+	row := make([]int, len(f.Net.State))
+	matched := false
+	for _, arc := range f.Process.OutputArcs[transitionIndex] {
+		if arc.Condition != "" {
+			result, err := expr.Eval(arc.Condition, f.Net.Variables)
+			if err != nil {
+				return nil, fmt.Errorf("transition %s, output condition %q: %v", f.transitionID(transitionIndex), arc.Condition, err)
+			}
+			if result != true {
+				continue
+			}
+		}
+		row[arc.Place] += arc.Weight
+		matched = true
+		if f.Process.splitMode(transitionIndex) == SplitXOR {
+			break
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("transition %s: %w", f.transitionID(transitionIndex), ErrNoOutput)
+	}
+	return row, nil
+}
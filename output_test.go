@@ -0,0 +1,89 @@
+package bpnet_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// test/output.yaml: start -> route (user) -> high | low | audit, the tp arcs carry the conditions
+func split(mode string) func(net *bpnet.ImportNet) {
+	return func(net *bpnet.ImportNet) {
+		net.Transition[0].Details = map[string]interface{}{"split": mode}
+	}
+}
+
+// the audit arc gets a condition which never holds
+func auditNever(net *bpnet.ImportNet) {
+	net.Arc[3].Condition = "amount < 0"
+}
+
+func fireOutput(t *testing.T, process bpnet.Process, amount int) (bpnet.Flow, error) {
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(process, "veith")
+	if err := f.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	return f, f.Fire(0, map[string]interface{}{"amount": amount})
+}
+
+func TestOutput_InclusiveOr(t *testing.T) {
+	process := buildfile(t, "test/output.yaml", split("or"))
+	f, err := fireOutput(t, process, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[1] != 1 || f.Net.State[2] != 1 || f.Net.State[3] != 1 {
+		t.Error("every matching arc should produce a token", f.Net.State)
+	}
+
+	f, err = fireOutput(t, process, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[1] != 0 || f.Net.State[2] != 0 || f.Net.State[3] != 1 {
+		t.Error("only the unconditioned arc should produce a token", f.Net.State)
+	}
+}
+
+func TestOutput_Xor(t *testing.T) {
+	process := buildfile(t, "test/output.yaml", split("xor"))
+	for amount, place := range map[int]int{500: 1, 50: 2, 5: 3} {
+		f, err := fireOutput(t, process, amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Net.State[place] != 1 || f.Net.State[1]+f.Net.State[2]+f.Net.State[3] != 1 {
+			t.Error("only the first matching arc should produce a token", amount, f.Net.State)
+		}
+	}
+}
+
+func TestOutput_NoMatch(t *testing.T) {
+	f, err := fireOutput(t, buildfile(t, "test/output.yaml", split("xor"), auditNever), 5)
+	if !errors.Is(err, bpnet.ErrNoOutput) {
+		t.Fatal("fire without a matching output arc should be rejected, got", err)
+	}
+	if f.Net.State[0] != 1 || f.Net.State[1]+f.Net.State[2]+f.Net.State[3] != 0 {
+		t.Error("rejected fire should not consume the token", f.Net.State)
+	}
+	if err := f.Fire(0, map[string]interface{}{"amount": 50}); err != nil || f.Net.State[2] != 1 {
+		t.Error("transition should fire once an arc matches", err, f.Net.State)
+	}
+}
+
+func TestValidateImportNet_Output(t *testing.T) {
+	net := bpnet.ImportNet{
+		Title:      "output",
+		Place:      []bpnet.Place{{ID: "start"}, {ID: "end"}},
+		Transition: []bpnet.Transition{{ID: "a", TransitionType: "auto", Details: map[string]interface{}{"split": "and"}}},
+		Arc: []bpnet.Arc{
+			{Source: "start", Destination: "a", Type: "pt"},
+			{Source: "a", Destination: "end", Type: "tp", Condition: "amount >"},
+		},
+	}
+	report := bpnet.ValidateImportNet(net)
+	if report.Len() != 2 {
+		t.Error("should report the split and the output condition, got", report.Issues)
+	}
+}
@@ -0,0 +1,77 @@
+package bpnet
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oklog/ulid"
+)
+
+// Action is what an actor wants to do with a flow
+type Action string
+
+const (
+	ActionStart        Action = "start"
+	ActionFire         Action = "fire"
+	ActionCompleteTask Action = "complete_task" // FireSystemTask
+)
+
+// Policy decides if an actor may start a flow, fire a transition or complete a system task.
+// transitionIndex is -1 for a start. The engine fires AUTO, MESSAGE and TIMED transitions
+// and completes subflows without asking the policy.
+type Policy interface {
+	Allow(ctx context.Context, actor Actor, action Action, flow *Flow, transitionIndex int) bool
+}
+
+// RolePolicy is the default policy. A transition with "roles" in its details may only
+// be fired by actors with one of the roles, transitions without roles are open for everyone.
+type RolePolicy struct{}
+
+func (RolePolicy) Allow(ctx context.Context, actor Actor, action Action, flow *Flow, transitionIndex int) bool {
+	if transitionIndex < 0 || transitionIndex >= len(flow.Process.Transitions) {
+		return true
+	}
+	roles := detailStrings(flow.Process.Transitions[transitionIndex].Details["roles"])
+	if len(roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		for _, has := range actor.Roles {
+			if role == has {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuthorizationError is returned when the policy denies an action
+type AuthorizationError struct {
+	Actor        string
+	Action       Action
+	FlowID       ulid.ULID
+	TransitionID string
+}
+
+func (e AuthorizationError) Error() string {
+	if e.TransitionID == "" {
+		return fmt.Sprintf("%q may not %s flow %s", e.Actor, e.Action, e.FlowID)
+	}
+	return fmt.Sprintf("%q may not %s %s of flow %s", e.Actor, e.Action, e.TransitionID, e.FlowID)
+}
+
+func (e *Engine) policy() Policy {
+	if e.Policy != nil {
+		return e.Policy
+	}
+	return RolePolicy{}
+}
+
+// asks the policy of the engine with the actor of the context
+func (f *Flow) authorize(ctx context.Context, action Action, transitionIndex int) error {
+	actor, _ := ActorFrom(ctx)
+	if f.Engine().policy().Allow(ctx, actor, action, f, transitionIndex) {
+		return nil
+	}
+	return AuthorizationError{Actor: actor.ID, Action: action, FlowID: f.ID, TransitionID: f.transitionID(transitionIndex)}
+}
@@ -0,0 +1,88 @@
+package bpnet_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+type denyStart struct{}
+
+func (denyStart) Allow(ctx context.Context, actor bpnet.Actor, action bpnet.Action, flow *bpnet.Flow, transitionIndex int) bool {
+	return action != bpnet.ActionStart
+}
+
+func TestPolicy_Roles(t *testing.T) {
+	var hookActor string
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(nil)
+	e.History = sink
+	e.Hooks = &bpnet.HandlerV2{
+		OnFireCompleted: func(ctx context.Context, flow *bpnet.Flow, transitionIndex int) error {
+			actor, _ := bpnet.ActorFrom(ctx)
+			hookActor = actor.ID
+			return nil
+		},
+	}
+	process := buildfile(t, "test/tokens.yaml")
+	process.Transitions[0].Details = map[string]interface{}{"roles": []interface{}{"clerk", "admin"}}
+	f := e.CreateFlow(process, "veith")
+	f.Start(nil)
+
+	err := f.Fire(0, nil)
+	if _, ok := err.(bpnet.AuthorizationError); !ok {
+		t.Error("fire without actor should be denied, got", err)
+	}
+	guest := bpnet.WithActor(context.Background(), bpnet.Actor{ID: "guest", Roles: []string{"visitor"}})
+	err = f.FireContext(guest, 0, nil)
+	if denied, ok := err.(bpnet.AuthorizationError); !ok || denied.Actor != "guest" || denied.TransitionID != "take" {
+		t.Error("fire without role should be denied, got", err)
+	}
+	if f.Net.State[0] != 3 {
+		t.Error("denied fire should not change the flow", f.Net.State)
+	}
+
+	clerk := bpnet.WithActor(context.Background(), bpnet.Actor{ID: "anna", Roles: []string{"clerk"}})
+	if err := f.FireContext(clerk, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if hookActor != "anna" {
+		t.Error("hooks should see the actor, got", hookActor)
+	}
+	events := sink.Events(f.ID)
+	if fired := events[len(events)-1]; fired.Type != bpnet.EventFired || fired.Actor != "anna" {
+		t.Error("fire should record the actor", fired)
+	}
+}
+
+func TestPolicy_Custom(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.Policy = denyStart{}
+	f := e.CreateFlow(buildfile(t, "test/tokens.yaml"), "veith")
+	err := f.Start(nil)
+	if denied, ok := err.(bpnet.AuthorizationError); !ok || denied.Action != bpnet.ActionStart {
+		t.Error("policy should deny the start, got", err)
+	}
+	if f.Net.TokenIds != nil {
+		t.Error("denied flow should not start")
+	}
+}
+
+func TestPolicy_SystemTaskTokenNotInProgress(t *testing.T) {
+	process := buildfile(t, "test/tokens.yaml")
+	process.Transitions[0].Details = map[string]interface{}{"roles": []interface{}{"approver"}}
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(process, "veith")
+	f.Start(nil)
+	if err := f.Claim(0, bpnet.Actor{ID: "anna"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ben := bpnet.WithActor(context.Background(), bpnet.Actor{ID: "ben", Roles: []string{"approver"}})
+	if err := f.FireSystemTaskContext(ben, 2, map[string]interface{}{"note": "x"}); err == nil {
+		t.Error("token which is not in a system task should be refused")
+	}
+	if f.Net.State[0] != 3 || len(f.Net.Variables) != 0 {
+		t.Error("refused completion should not change the flow", f.Net.State, f.Net.Variables)
+	}
+}
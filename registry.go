@@ -0,0 +1,99 @@
+package bpnet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ProcessRef names a version of a process as name@version, a ref without version means the latest
+func ProcessRef(name string, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + "@" + version
+}
+
+// ParseProcessRef splits name@version, the version is empty for the latest
+func ParseProcessRef(ref string) (name string, version string) {
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// ProcessRegistry keeps all versions of the process definitions.
+// Its Load method can be used as ProcessDefinitionLoader.
+type ProcessRegistry struct {
+	mu       sync.RWMutex
+	versions map[string][]Process // versions per name, the last one is the latest
+}
+
+func NewProcessRegistry() *ProcessRegistry {
+	return &ProcessRegistry{versions: make(map[string][]Process)}
+}
+
+// Register adds a version of a process, it becomes the latest.
+// Registering a known version again replaces the definition and keeps its position.
+func (r *ProcessRegistry) Register(p Process) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.versions == nil {
+		r.versions = make(map[string][]Process)
+	}
+	versions := r.versions[p.Name]
+	for i, known := range versions {
+		if known.Version == p.Version {
+			versions[i] = p
+			return
+		}
+	}
+	r.versions[p.Name] = append(versions, p)
+}
+
+// Get returns a version of a process, the latest for an empty version
+func (r *ProcessRegistry) Get(name string, version string) (*Process, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := r.versions[name]
+	if len(versions) == 0 {
+		return nil, false
+	}
+	if version == "" {
+		p := versions[len(versions)-1]
+		return &p, true
+	}
+	for _, known := range versions {
+		if known.Version == version {
+			p := known
+			return &p, true
+		}
+	}
+	return nil, false
+}
+
+// Load resolves name or name@version
+func (r *ProcessRegistry) Load(ref string) (*Process, error) {
+	name, version := ParseProcessRef(ref)
+	p, ok := r.Get(name, version)
+	if !ok {
+		return nil, fmt.Errorf("process %s is not registered", ref)
+	}
+	return p, nil
+}
+
+// LoadSnapshot restores a snapshot with the process version the flow was started with,
+// the process is loaded via ProcessDefinitionLoader
+func (e *Engine) LoadSnapshot(ctx context.Context, data []byte) (Flow, error) {
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Flow{}, err
+	}
+	process, err := e.loadProcess(ctx, ProcessRef(s.ProcessName, s.ProcessVersion))
+	if err != nil {
+		return Flow{}, err
+	}
+	return e.RestoreFlow(*process, data)
+}
@@ -0,0 +1,97 @@
+package bpnet_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/veith/bpnet"
+)
+
+func TestMakeProcessFromYaml_Version(t *testing.T) {
+	a := readfile("test/looper.yaml")
+	b := readfile("test/looper.yaml")
+	if a.Version == "" || a.Version != b.Version {
+		t.Error("same definitions should get the same content version", a.Version, b.Version)
+	}
+	if c := readfile("test/msg-sys.yaml"); c.Version == a.Version {
+		t.Error("other definitions should get another version", c.Version)
+	}
+
+	var net bpnet.ImportNet
+	yaml.Unmarshal([]byte("title: proc.sample\nversion: \"3\"\n"), &net)
+	if p := bpnet.MakeProcessFromYaml(net); p.Version != "3" {
+		t.Error("explicit version should be used, got", p.Version)
+	}
+}
+
+func TestProcessRef(t *testing.T) {
+	if name, version := bpnet.ParseProcessRef("proc.sample@3"); name != "proc.sample" || version != "3" {
+		t.Error("ref should split into name and version", name, version)
+	}
+	if name, version := bpnet.ParseProcessRef("proc.sample"); name != "proc.sample" || version != "" {
+		t.Error("ref without version should mean the latest", name, version)
+	}
+	if ref := bpnet.ProcessRef("proc.sample", "3"); ref != "proc.sample@3" {
+		t.Error("ref should be name@version, got", ref)
+	}
+}
+
+func TestProcessRegistry_PinnedFlows(t *testing.T) {
+	registry := bpnet.NewProcessRegistry()
+	v1 := buildfile(t, "test/tokens.yaml")
+	v1.Version = "1"
+	registry.Register(v1)
+	e := bpnet.NewEngine(&bpnet.Handler{ProcessDefinitionLoader: registry.Load})
+
+	latest, _ := registry.Load("tokens")
+	running := e.CreateFlow(*latest, "veith")
+	running.Start(nil)
+
+	v2 := buildfile(t, "test/tokens.yaml")
+	v2.Version = "2"
+	v2.Transitions[0].Details = map[string]interface{}{"form": "new"}
+	registry.Register(v2)
+
+	latest, _ = registry.Load("tokens")
+	if next := e.CreateFlow(*latest, "veith"); next.ProcessVersion != "2" {
+		t.Error("new flows should start with the latest version, got", next.ProcessVersion)
+	}
+	if running.ProcessVersion != "1" {
+		t.Error("running flows should keep their version, got", running.ProcessVersion)
+	}
+
+	data, err := running.MarshalSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := e.LoadSnapshot(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Process.Version != "1" || restored.Process.Transitions[0].Details != nil {
+		t.Error("snapshot should be restored with the version it was started with, got", restored.Process.Version)
+	}
+	if _, err := bpnet.RestoreFlow(v2, data); err == nil {
+		t.Error("restoring with another version should fail")
+	}
+}
+
+func TestProcessRegistry_ReRegister(t *testing.T) {
+	registry := bpnet.NewProcessRegistry()
+	v1 := buildfile(t, "test/tokens.yaml")
+	v1.Version = "1"
+	registry.Register(v1)
+	v2 := buildfile(t, "test/tokens.yaml")
+	v2.Version = "2"
+	registry.Register(v2)
+
+	v1.Transitions[0].Details = map[string]interface{}{"form": "fixed"}
+	registry.Register(v1)
+	if latest, _ := registry.Get("tokens", ""); latest.Version != "2" {
+		t.Error("registering a known version again should not make it the latest, got", latest.Version)
+	}
+	if p, _ := registry.Get("tokens", "1"); p.Transitions[0].Details["form"] != "fixed" {
+		t.Error("known version should be replaced", p.Transitions[0].Details)
+	}
+}
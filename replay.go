@@ -0,0 +1,341 @@
+package bpnet
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Replay rebuilds a flow from its history. No hooks are called and no timers are armed,
+// the flow has the marking, token ids, variables and transitions in progress of the last event.
+// The events name transitions by id, so each transition of the process needs a unique id.
+// A migrated history needs the other versions, see ReplayVersions.
+func Replay(process Process, events []Event) (Flow, error) {
+	return ReplayVersions(process, events, nil)
+}
+
+// ReplayVersions is Replay for a history with migrations. process is the version the flow
+// runs now, load returns the versions before as name@version, e.g. ProcessRegistry.Load.
+func ReplayVersions(process Process, events []Event, load ProcessDefinitionLoader) (Flow, error) {
+	if len(events) == 0 || events[0].Type != EventStarted {
+		return Flow{}, fmt.Errorf("history has to begin with a %s event", EventStarted)
+	}
+	version := func(v string) (Process, error) {
+		if v == process.Version {
+			return process, nil
+		}
+		if load == nil {
+			return Process{}, fmt.Errorf("history needs version %q of process %q", v, process.Name)
+		}
+		p, err := load(ProcessRef(process.Name, v))
+		if err != nil {
+			return Process{}, err
+		}
+		if p == nil || p.Version != v {
+			return Process{}, fmt.Errorf("version %q of process %q can not be loaded", v, process.Name)
+		}
+		return *p, nil
+	}
+
+	// der verlauf beginnt mit der version vor der ersten migration
+	start := process
+	for _, event := range events {
+		if event.Type == EventMigrated {
+			var err error
+			if start, err = version(event.FromVersion); err != nil {
+				return Flow{}, err
+			}
+			break
+		}
+	}
+
+	flow := Flow{ID: events[0].FlowID, Owner: events[0].Actor}
+	flow.Net.Variables = make(map[string]interface{})
+	flow.TransitionsInProgress = make(map[int]int)
+	flow.TimersDue = make(map[int]time.Time)
+	types, transitions, err := flow.replayProcess(start)
+	if err != nil {
+		return flow, err
+	}
+
+	for i, event := range events {
+		if event.FlowID != flow.ID {
+			return flow, fmt.Errorf("event %d belongs to flow %s", event.Seq, event.FlowID)
+		}
+		if i > 0 && event.Seq <= events[i-1].Seq {
+			return flow, fmt.Errorf("event %d is out of order", event.Seq)
+		}
+		flow.EventSeq = event.Seq
+
+		if event.Type == EventMigrated {
+			target, err := version(event.ToVersion)
+			if err == nil {
+				types, transitions, err = flow.replayMigration(event, target)
+			}
+			if err != nil {
+				return flow, fmt.Errorf("event %d: %v", event.Seq, err)
+			}
+			continue
+		}
+
+		for _, change := range event.Changes {
+			value, ok := coerceValue(types[change.Name], change.After)
+			if !ok {
+				return flow, fmt.Errorf("event %d: variable %s does not match type %s", event.Seq, change.Name, types[change.Name])
+			}
+			flow.Net.Variables[change.Name] = value
+		}
+		for _, token := range event.Consumed {
+			if err := flow.replayConsume(token); err != nil {
+				return flow, fmt.Errorf("event %d: %v", event.Seq, err)
+			}
+		}
+		for _, token := range event.Produced {
+			if token.Place >= len(flow.Net.State) {
+				return flow, fmt.Errorf("event %d: place %d does not exist", event.Seq, token.Place)
+			}
+			flow.Net.State[token.Place]++
+			flow.Net.TokenIds[token.Place] = append(flow.Net.TokenIds[token.Place], token.TokenID)
+			flow.TokenCounter = max(flow.TokenCounter, token.TokenID)
+		}
+
+		switch event.Type {
+		case EventTimerArmed, EventSystemTaskDispatched, EventSubflowStarted, EventMessageAwaited, EventSignalAwaited:
+			transition, ok := transitions[event.TransitionID]
+			if !ok {
+				return flow, fmt.Errorf("event %d: unknown transition %q", event.Seq, event.TransitionID)
+			}
+			flow.TransitionsInProgress[event.TokenID] = transition
+			if event.Due != nil {
+				flow.TimersDue[event.TokenID] = *event.Due
+			}
+			if event.SubflowID != nil {
+				flow.RunningSubProcesses = append(flow.RunningSubProcesses, *event.SubflowID)
+			}
+			if event.Type == EventMessageAwaited {
+				if flow.Subscriptions == nil {
+					flow.Subscriptions = make(map[int]MessageSubscription)
+				}
+				flow.Subscriptions[event.TokenID] = MessageSubscription{FlowID: flow.ID, TokenID: event.TokenID, Message: event.Message, Keys: event.Correlation}
+			}
+		case EventTimerFired, EventSystemTaskCompleted, EventSubflowCompleted, EventSystemTaskFailed, EventMessageReceived, EventSignalReceived:
+			delete(flow.TransitionsInProgress, event.TokenID)
+			delete(flow.TimersDue, event.TokenID)
+			flow.resolveIncident(event.TokenID)
+			delete(flow.Attempts, event.TokenID)
+			delete(flow.Subscriptions, event.TokenID)
+		case EventRetryScheduled:
+			if flow.Attempts == nil {
+				flow.Attempts = make(map[int]int)
+			}
+			flow.Attempts[event.TokenID] = event.Attempt - 1
+			if event.Due != nil {
+				flow.TimersDue[event.TokenID] = *event.Due
+			}
+		case EventSystemTaskRetried:
+			delete(flow.TimersDue, event.TokenID)
+		case EventIncident:
+			delete(flow.Attempts, event.TokenID)
+			flow.raiseIncident(event.TokenID, transitions[event.TransitionID], event.Error, event.Time)
+		case EventTaskClaimed, EventTaskAssigned:
+			transition, ok := transitions[event.TransitionID]
+			if !ok {
+				return flow, fmt.Errorf("event %d: unknown transition %q", event.Seq, event.TransitionID)
+			}
+			if flow.Assignees == nil {
+				flow.Assignees = make(map[int]string)
+			}
+			flow.Assignees[transition] = event.Assignee
+		case EventTaskUnclaimed:
+			delete(flow.Assignees, transitions[event.TransitionID])
+		case EventFired:
+			delete(flow.Assignees, transitions[event.TransitionID])
+		case EventStarted:
+			flow.Status = StatusRunning
+		case EventCompleted:
+			flow.Status = StatusCompleted
+		case EventCancelled:
+			flow.Status = StatusCancelled
+			flow.CancelReason = event.Reason
+			flow.TransitionsInProgress = make(map[int]int)
+			flow.TimersDue = make(map[int]time.Time)
+			flow.RunningSubProcesses = nil
+			flow.Assignees = nil
+			flow.Incidents = nil
+			flow.Attempts = nil
+			flow.Subscriptions = nil
+		case EventSuspended:
+			flow.Suspended = true
+		case EventResumed:
+			flow.Suspended = false
+		case EventSubflowFailed:
+			delete(flow.TransitionsInProgress, event.TokenID)
+			if event.SubflowID != nil {
+				flow.RunningSubProcesses = removeULID(flow.RunningSubProcesses, *event.SubflowID)
+			}
+		}
+	}
+
+	// enabled transitions neu berechnen, die token ids bleiben wie im verlauf
+	flow.rebuildNet()
+	flow.pruneAssignees()
+	return flow, nil
+}
+
+// binds the flow to a version of the process, the marking is emptied.
+// Returns the declared variable types and the transition indexes of the version.
+func (flow *Flow) replayProcess(process Process) (map[string]string, map[string]int, error) {
+	transitions, err := transitionIndexes(process)
+	if err != nil {
+		return nil, nil, err
+	}
+	flow.Process = process
+	flow.ProcessName = process.Name
+	flow.ProcessVersion = process.Version
+	flow.Net.InputMatrix = process.InputMatrix
+	flow.Net.OutputMatrix = process.OutputMatrix
+	flow.Net.ConditionMatrix = process.ConditionMatrix
+	flow.Net.State = make([]int, len(process.InitialState))
+	flow.Net.TokenIds = make([][]int, len(process.InitialState))
+
+	// werte aus json verlauf wieder in die deklarierten typen bringen
+	types := make(map[string]string)
+	for _, variable := range process.Variables {
+		types[variable.ID] = variable.Type
+	}
+	return types, transitions, nil
+}
+
+// the events name transitions by id, every transition needs its own
+func transitionIndexes(process Process) (map[string]int, error) {
+	if len(process.Transitions) < len(process.TransitionTypes) {
+		return nil, fmt.Errorf("transitions of process %q have no ids", process.Name)
+	}
+	indexes := make(map[string]int, len(process.Transitions))
+	for index, transition := range process.Transitions {
+		if transition.ID == "" {
+			return nil, fmt.Errorf("transition %d of process %q has no id", index, process.Name)
+		}
+		if _, ok := indexes[transition.ID]; ok {
+			return nil, fmt.Errorf("transition id %q of process %q is not unique", transition.ID, process.Name)
+		}
+		indexes[transition.ID] = index
+	}
+	return indexes, nil
+}
+
+// moves the flow to the target version like Migrate: the tokens are taken from the old
+// places and put on the new ones, transitions in progress, assignees and incidents follow their ids.
+// Returns the variable types and transition indexes of the target.
+func (flow *Flow) replayMigration(event Event, target Process) (map[string]string, map[string]int, error) {
+	for _, token := range event.Consumed {
+		if err := flow.replayConsume(token); err != nil {
+			return nil, nil, err
+		}
+	}
+	newID := func(transition int) string {
+		id := flow.transitionID(transition)
+		if renamed, ok := event.Renamed[id]; ok {
+			return renamed
+		}
+		return id
+	}
+	indexes, err := transitionIndexes(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	inProgress := make(map[int]int)
+	for tokenID, transition := range flow.TransitionsInProgress {
+		index, ok := indexes[newID(transition)]
+		if !ok {
+			return nil, nil, fmt.Errorf("transition %q of token %d does not exist in version %s", newID(transition), tokenID, target.Version)
+		}
+		inProgress[tokenID] = index
+	}
+	var assignees map[int]string
+	for transition, user := range flow.Assignees {
+		if index, ok := indexes[newID(transition)]; ok {
+			if assignees == nil {
+				assignees = make(map[int]string)
+			}
+			assignees[index] = user
+		}
+	}
+	for i := range flow.Incidents {
+		flow.Incidents[i].Transition = inProgress[flow.Incidents[i].TokenID]
+		flow.Incidents[i].TransitionID = target.Transitions[flow.Incidents[i].Transition].ID
+	}
+
+	types, transitions, err := flow.replayProcess(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	flow.TransitionsInProgress = inProgress
+	flow.Assignees = assignees
+	for _, token := range event.Produced {
+		if token.Place >= len(flow.Net.State) {
+			return nil, nil, fmt.Errorf("place %d does not exist in version %s", token.Place, target.Version)
+		}
+		flow.Net.State[token.Place]++
+		flow.Net.TokenIds[token.Place] = append(flow.Net.TokenIds[token.Place], token.TokenID)
+	}
+	return types, transitions, nil
+}
+
+// removes a token like the net does: the token is swapped to the front of the place and popped
+func (flow *Flow) replayConsume(token PlacedToken) error {
+	if token.Place >= len(flow.Net.TokenIds) {
+		return fmt.Errorf("place %d does not exist", token.Place)
+	}
+	ids := flow.Net.TokenIds[token.Place]
+	for index, id := range ids {
+		if id == token.TokenID {
+			ids[index] = ids[0]
+			ids[0] = id
+			flow.Net.TokenIds[token.Place] = ids[1:]
+			flow.Net.State[token.Place]--
+			return nil
+		}
+	}
+	return fmt.Errorf("token %d is not on place %d", token.TokenID, token.Place)
+}
+
+// VerifyHistory replays the history and reports the first difference to the flow.
+// The versions before a migration are loaded via ProcessDefinitionLoader.
+func VerifyHistory(flow *Flow, events []Event) error {
+	e := flow.Engine()
+	load := func(ref string) (*Process, error) {
+		return e.loadProcess(context.Background(), ref)
+	}
+	replayed, err := ReplayVersions(flow.Process, events, load)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !reflect.DeepEqual(normalizeTokens(replayed.Net.TokenIds), normalizeTokens(flow.Net.TokenIds)):
+		return fmt.Errorf("token ids differ: flow %v, history %v", flow.Net.TokenIds, replayed.Net.TokenIds)
+	case !reflect.DeepEqual(replayed.Net.State, flow.Net.State):
+		return fmt.Errorf("state differs: flow %v, history %v", flow.Net.State, replayed.Net.State)
+	case !reflect.DeepEqual(replayed.TransitionsInProgress, flow.TransitionsInProgress):
+		return fmt.Errorf("transitions in progress differ: flow %v, history %v", flow.TransitionsInProgress, replayed.TransitionsInProgress)
+	case len(replayed.Incidents) != len(flow.Incidents):
+		return fmt.Errorf("incidents differ: flow %v, history %v", flow.Incidents, replayed.Incidents)
+	case replayed.Suspended != flow.Suspended:
+		return fmt.Errorf("suspension differs: flow %v, history %v", flow.Suspended, replayed.Suspended)
+	case replayed.TokenCounter != flow.TokenCounter:
+		return fmt.Errorf("token counter differs: flow %d, history %d", flow.TokenCounter, replayed.TokenCounter)
+	case !reflect.DeepEqual(replayed.Net.Variables, flow.Net.Variables):
+		return fmt.Errorf("variables differ: flow %v, history %v", flow.Net.Variables, replayed.Net.Variables)
+	}
+	return nil
+}
+
+// empty and nil places are the same
+func normalizeTokens(tokenIds [][]int) [][]int {
+	n := make([][]int, len(tokenIds))
+	for i, ids := range tokenIds {
+		n[i] = append([]int{}, ids...)
+	}
+	return n
+}
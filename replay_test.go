@@ -0,0 +1,105 @@
+package bpnet_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+func TestReplay_RebuildsFlow(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	f, _ := runHistoryProcess(t, sink)
+
+	replayed, err := bpnet.Replay(buildfile(t, "test/history.yaml"), sink.Events(f.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed.Net.State, f.Net.State) {
+		t.Error("state should be replayed", replayed.Net.State, f.Net.State)
+	}
+	if !reflect.DeepEqual(replayed.ReadData(), f.ReadData()) {
+		t.Error("variables should be replayed", replayed.ReadData(), f.ReadData())
+	}
+	if err := bpnet.VerifyHistory(f, sink.Events(f.ID)); err != nil {
+		t.Error("flow should match its history", err)
+	}
+}
+
+func TestReplay_InProgress(t *testing.T) {
+	var buf bytes.Buffer
+	clock := newManualClock()
+	var hooks int
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnTimerStarted: func(flow *bpnet.Flow, transitionIndex int) bool {
+			hooks++
+			return true
+		},
+	})
+	e.Clock = clock
+	e.History = bpnet.NewJSONLinesSink(&buf)
+
+	f := e.CreateFlow(buildfile(t, "test/history.yaml"), "veith")
+	f.Start(nil)
+	f.Fire(0, map[string]interface{}{"note": "approved"})
+
+	// verlauf aus dem json lesen
+	var events []bpnet.Event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var event bpnet.Event
+		json.Unmarshal(scanner.Bytes(), &event)
+		events = append(events, event)
+	}
+
+	replayed, err := bpnet.Replay(buildfile(t, "test/history.yaml"), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooks != 1 {
+		t.Error("replay should not call hooks, called", hooks)
+	}
+	if !reflect.DeepEqual(replayed.TransitionsInProgress, f.TransitionsInProgress) {
+		t.Error("timer should be in progress", replayed.TransitionsInProgress, f.TransitionsInProgress)
+	}
+	if !reflect.DeepEqual(replayed.Net.TokenIds, f.Net.TokenIds) {
+		t.Error("token ids should be replayed", replayed.Net.TokenIds, f.Net.TokenIds)
+	}
+	if err := bpnet.VerifyHistory(&f, events); err != nil {
+		t.Error("flow should match its history", err)
+	}
+
+	f.Net.State[3] = 1
+	if err := bpnet.VerifyHistory(&f, events); err == nil {
+		t.Error("tampered flow should not match its history")
+	}
+}
+
+func TestReplay_RejectsForeignEvents(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	f, _ := runHistoryProcess(t, sink)
+	g, _ := runHistoryProcess(t, sink)
+
+	events := append(sink.Events(f.ID), sink.Events(g.ID)[3])
+	if _, err := bpnet.Replay(buildfile(t, "test/history.yaml"), events); err == nil {
+		t.Error("events of other flows should be rejected")
+	}
+}
+
+func TestReplay_RejectsTransitionsWithoutIds(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	f, _ := runHistoryProcess(t, sink)
+
+	process := buildfile(t, "test/history.yaml")
+	process.Transitions[2].ID = process.Transitions[0].ID
+	if _, err := bpnet.Replay(process, sink.Events(f.ID)); err == nil {
+		t.Error("transitions with the same id should be rejected")
+	}
+	process.Transitions = nil
+	if _, err := bpnet.Replay(process, sink.Events(f.ID)); err == nil {
+		t.Error("transitions without ids should be rejected")
+	}
+}
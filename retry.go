@@ -0,0 +1,180 @@
+package bpnet
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy dispatches a failed system task again, it is read from Details["retry"]:
+//
+//	retry:
+//	  attempts: 5        # attempts including the first dispatch, default 3
+//	  backoff: 10s       # delay before the first retry like the delay of a TIMED transition, default 1s
+//	  maxbackoff: 5m     # upper bound of the delay, optional
+//	  multiplier: 2      # growth of the delay per retry, default 2
+//	  jitter: 0.1        # random part of the delay, 0.1 means ±10%
+//
+// The retries are scheduled on the engine clock and kept in the TimerStore like timers.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// defaults of a retry policy without attempts or backoff
+const (
+	defaultAttempts = 3
+	defaultBackoff  = time.Second
+)
+
+type attemptKey struct{}
+
+// AttemptFrom returns the attempt of a system task in OnSystemTask, starting with 1.
+// Legacy handlers read Flow.Attempts[tokenID]+1.
+func AttemptFrom(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	return attempt
+}
+
+// delay before the retry after the given number of failed attempts
+func (p RetryPolicy) backoff(failed int) time.Duration {
+	d := float64(p.Backoff) * math.Pow(p.Multiplier, float64(failed-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// the retry policy of a transition, an invalid policy is reported by ValidateImportNet
+func (p Process) retryPolicy(transitionIndex int) (RetryPolicy, bool) {
+	if transitionIndex >= len(p.Transitions) {
+		return RetryPolicy{}, false
+	}
+	policy, err := parseRetryPolicy(p.Transitions[transitionIndex].Details["retry"])
+	if err != nil || policy.Attempts < 2 {
+		return RetryPolicy{}, false
+	}
+	return policy, true
+}
+
+func parseRetryPolicy(value interface{}) (RetryPolicy, error) {
+	policy := RetryPolicy{Backoff: defaultBackoff, Multiplier: 2}
+	if value == nil {
+		return policy, nil
+	}
+	details, ok := value.(map[string]interface{})
+	if !ok {
+		return policy, fmt.Errorf("retry policy of type %T is not supported", value)
+	}
+	policy.Attempts = defaultAttempts
+	if v, ok := details["attempts"]; ok {
+		attempts, ok := detailNumber(v)
+		if !ok || attempts < 1 || attempts != math.Trunc(attempts) {
+			return policy, fmt.Errorf("retry attempts %v is not a positive number", v)
+		}
+		policy.Attempts = int(attempts)
+	}
+	for key, target := range map[string]*time.Duration{"backoff": &policy.Backoff, "maxbackoff": &policy.MaxBackoff} {
+		v, ok := details[key]
+		if !ok {
+			continue
+		}
+		now := time.Now()
+		due, err := dueTime(v, now, nil)
+		if err != nil {
+			return policy, fmt.Errorf("retry %s: %v", key, err)
+		}
+		if due.Before(now) {
+			return policy, fmt.Errorf("retry %s %v is negative", key, v)
+		}
+		*target = due.Sub(now)
+	}
+	if v, ok := details["multiplier"]; ok {
+		multiplier, ok := detailNumber(v)
+		if !ok || multiplier < 1 {
+			return policy, fmt.Errorf("retry multiplier %v is smaller than 1", v)
+		}
+		policy.Multiplier = multiplier
+	}
+	if v, ok := details["jitter"]; ok {
+		jitter, ok := detailNumber(v)
+		if !ok || jitter < 0 || jitter > 1 {
+			return policy, fmt.Errorf("retry jitter %v is not between 0 and 1", v)
+		}
+		policy.Jitter = jitter
+	}
+	return policy, nil
+}
+
+// yaml gives float64, details set in go may be int
+func detailNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// schedules the next attempt of a failed system task on the engine clock.
+// If the TimerStore refuses the retry, the failure is not counted.
+func (f *Flow) scheduleRetry(tokenID int, transition int, failed int, delay time.Duration, message string, actor string) error {
+	e := f.Engine()
+	due := e.clock().Now().Add(delay)
+	if err := e.timerStore().Schedule(ScheduledTimer{FlowID: f.ID, TokenID: tokenID, Transition: transition, Due: due}); err != nil {
+		return err
+	}
+	if f.Attempts == nil {
+		f.Attempts = make(map[int]int)
+	}
+	if f.TimersDue == nil {
+		f.TimersDue = make(map[int]time.Time)
+	}
+	f.Attempts[tokenID] = failed
+	f.TimersDue[tokenID] = due
+	f.record(Event{Type: EventRetryScheduled, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: actor, Due: &due, Attempt: failed + 1, Error: message})
+	e.armTimer(f, tokenID, due)
+	return nil
+}
+
+// dispatches a system task again when its retry is due. A handler which does not take
+// the task counts as another failed attempt.
+func (f *Flow) retrySystemTask(ctx context.Context, tokenID int, transition int) error {
+	e := f.Engine()
+	f.record(Event{Type: EventSystemTaskRetried, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: ActorTimer, Attempt: f.Attempts[tokenID] + 1})
+	dispatched, err := e.systemTask(ctx, f, tokenID, transition)
+	if dispatched {
+		return err
+	}
+	failure := err
+	if failure == nil {
+		failure = ErrNotHandled
+	}
+	return firstError(err, f.failSystemTask(ctx, tokenID, transition, failure, ActorTimer))
+}
+
+// a completed system task needs no retry
+func (f *Flow) cancelRetry(tokenID int) error {
+	if _, waiting := f.Attempts[tokenID]; !waiting {
+		return nil
+	}
+	delete(f.Attempts, tokenID)
+	if _, ok := f.TimersDue[tokenID]; ok {
+		delete(f.TimersDue, tokenID)
+		return f.Engine().disarmTimer(f, tokenID)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package bpnet_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/veith/bpnet"
+)
+
+func retryEngine(attempts *[]int) (*bpnet.Engine, *manualClock, *bpnet.MemorySink) {
+	clock := newManualClock()
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(nil)
+	e.Clock = clock
+	e.History = sink
+	e.Hooks = &bpnet.HandlerV2{
+		OnSystemTask: func(ctx context.Context, flow *bpnet.Flow, tokenID int, transitionIndex int) error {
+			*attempts = append(*attempts, bpnet.AttemptFrom(ctx))
+			return nil
+		},
+	}
+	return e, clock, sink
+}
+
+// test/retry.yaml: start -> split (auto) -> p1 + p2, p1 -> task (system, 3 attempts) -> p3, p2 -> review (user) -> p4
+
+func TestRetry_Backoff(t *testing.T) {
+	var attempts []int
+	e, clock, sink := retryEngine(&attempts)
+	f := e.CreateFlow(buildfile(t, "test/retry.yaml"), "veith")
+	f.Start(nil)
+
+	if err := f.FailSystemTask(2, errors.New("503")); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Incidents) != 0 {
+		t.Fatal("task with retries left should not raise an incident")
+	}
+	clock.Advance(9 * time.Second)
+	if len(attempts) != 1 {
+		t.Error("retry should wait for its backoff, attempts", attempts)
+	}
+	clock.Advance(time.Second)
+	f.FailSystemTask(2, errors.New("503"))
+	clock.Advance(19 * time.Second)
+	if len(attempts) != 2 {
+		t.Error("second retry should wait twice as long, attempts", attempts)
+	}
+	clock.Advance(time.Second)
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[1] != 2 || attempts[2] != 3 {
+		t.Fatal("handler should see the attempts, got", attempts)
+	}
+
+	// versuche aufgebraucht
+	if err := f.FailSystemTask(2, errors.New("503")); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Incidents) != 1 || f.Status != bpnet.StatusFailed {
+		t.Error("last failed attempt should escalate, incidents", f.Incidents)
+	}
+	if timers, _ := e.Timers.List(); len(timers) != 0 {
+		t.Error("no retry should be left", timers)
+	}
+	if err := bpnet.VerifyHistory(&f, sink.Events(f.ID)); err != nil {
+		t.Error("history should match the flow", err)
+	}
+}
+
+func TestRetry_CompletedBeforeRetry(t *testing.T) {
+	var attempts []int
+	e, clock, _ := retryEngine(&attempts)
+	f := e.CreateFlow(buildfile(t, "test/retry.yaml"), "veith")
+	f.Start(nil)
+
+	f.FailSystemTask(2, errors.New("503"))
+	// eine späte antwort des ersten versuchs schließt den task ab
+	if err := f.FireSystemTask(2, nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if len(attempts) != 1 {
+		t.Error("completed task should not be retried, attempts", attempts)
+	}
+	if len(f.Attempts) != 0 || len(f.TimersDue) != 0 {
+		t.Error("retry should be removed", f.Attempts, f.TimersDue)
+	}
+}
+
+func TestValidateImportNet_RetryPolicy(t *testing.T) {
+	net := bpnet.ImportNet{
+		Title:      "retry",
+		Transition: []bpnet.Transition{{ID: "call", TransitionType: "system", Details: map[string]interface{}{"retry": map[string]interface{}{"jitter": 2.0}}}},
+	}
+	report := bpnet.ValidateImportNet(net)
+	if report.Len() != 1 || report.Issues[0].Path != "transitions[0]" {
+		t.Error("invalid jitter should be reported, got", report.Issues)
+	}
+}
+
+func TestRetry_StoreFails(t *testing.T) {
+	var attempts []int
+	e, clock, sink := retryEngine(&attempts)
+	e.Timers = brokenTimerStore(t)
+	f := e.CreateFlow(buildfile(t, "test/retry.yaml"), "veith")
+	f.Start(nil)
+
+	if err := f.FailSystemTask(2, errors.New("503")); err == nil {
+		t.Fatal("retry which can not be stored should be reported")
+	}
+	if len(f.Attempts) != 0 || len(f.TimersDue) != 0 {
+		t.Error("failure should not be counted", f.Attempts, f.TimersDue)
+	}
+	for _, event := range sink.Events(f.ID) {
+		if event.Type == bpnet.EventRetryScheduled {
+			t.Error("retry should not be recorded", event)
+		}
+	}
+	clock.Advance(time.Hour)
+	if len(attempts) != 1 {
+		t.Error("task should not be dispatched again", attempts)
+	}
+}
@@ -0,0 +1,97 @@
+package bpnet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/antonmedv/expr"
+	"github.com/oklog/ulid"
+)
+
+// parks a token of a SIGNAL transition until Engine.Signal broadcasts details.signal
+func (f *Flow) awaitSignal(transition int, tokenID int) {
+	name, _ := f.Process.Transitions[transition].Details["signal"].(string)
+	f.TransitionsInProgress[tokenID] = transition
+	f.record(Event{Type: EventSignalAwaited, TransitionID: f.transitionID(transition), TokenID: tokenID, Actor: ActorEngine, Signal: name})
+}
+
+// Signal broadcasts a signal to all flows of the engine, see SignalContext
+func (e *Engine) Signal(name string, payload map[string]interface{}) ([]ulid.ULID, error) {
+	return e.SignalContext(context.Background(), name, payload, "")
+}
+
+// SignalContext fires every token waiting for the signal in the flows running on this engine.
+// The payload is taken over into the variables of each flow. With a filter only flows whose
+// variables match the expression react, e.g. `productId == "4711"`. Suspended flows miss the signal,
+// it is not held for them like a message.
+// The ids of the flows which reacted are returned, the first error of a flow is returned after all flows got the signal.
+func (e *Engine) SignalContext(ctx context.Context, name string, payload map[string]interface{}, filter string) ([]ulid.ULID, error) {
+	if filter != "" {
+		if _, err := expr.Compile(filter, expr.AsBool()); err != nil {
+			return nil, fmt.Errorf("signal filter %q: %v", filter, err)
+		}
+	}
+	flows := e.Flows()
+	sort.Slice(flows, func(i, j int) bool { return flows[i].ID.Compare(flows[j].ID) < 0 })
+
+	var reacted []ulid.ULID
+	var err error
+	for _, flow := range flows {
+		caught, catchErr := flow.catchSignal(ctx, name, payload, filter)
+		if caught {
+			reacted = append(reacted, flow.ID)
+		}
+		err = firstError(err, catchErr)
+	}
+	return reacted, err
+}
+
+// fires the tokens of the flow waiting for the signal, tokens which start to wait
+// while the signal is handled do not get it
+func (f *Flow) catchSignal(ctx context.Context, name string, payload map[string]interface{}, filter string) (bool, error) {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	// ein angehaltener flow verpasst das signal
+	if f.checkActive() != nil || f.Suspended {
+		return false, nil
+	}
+	var tokens []int
+	for tokenID, transition := range f.TransitionsInProgress {
+		if f.Process.TransitionTypes[transition] != int(SIGNAL) {
+			continue
+		}
+		if signal, _ := f.Process.Transitions[transition].Details["signal"].(string); signal == name {
+			tokens = append(tokens, tokenID)
+		}
+	}
+	if len(tokens) == 0 {
+		return false, nil
+	}
+	if filter != "" {
+		match, err := expr.Eval(filter, f.Net.Variables)
+		if err != nil {
+			return false, fmt.Errorf("signal filter %q on flow %s: %v", filter, f.ID, err)
+		}
+		if match != true {
+			return false, nil
+		}
+	}
+	sort.Ints(tokens)
+
+	changes, err := f.appendData(payload, "___signal")
+	if err != nil {
+		return false, err
+	}
+	actor := actorID(ctx, ActorSystem)
+	for _, tokenID := range tokens {
+		if _, waiting := f.TransitionsInProgress[tokenID]; !waiting {
+			continue
+		}
+		// die änderungen der variablen stehen beim ersten token
+		_, fireErr := f.fireWithTokenId(ctx, tokenID, Event{Type: EventSignalReceived, TokenID: tokenID, Actor: actor, Changes: changes, Signal: name})
+		err = firstError(err, fireErr)
+		changes = nil
+	}
+	return true, err
+}
@@ -0,0 +1,47 @@
+package bpnet_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// test/signal.yaml: start -> discontinued (signal) -> stopped
+
+func TestSignal_Broadcast(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.History = sink
+	flows := make([]bpnet.Flow, 3)
+	for i, product := range []string{"4711", "4711", "0815"} {
+		flows[i] = e.CreateFlow(buildfile(t, "test/signal.yaml"), "veith")
+		flows[i].Start(map[string]interface{}{"productId": product})
+	}
+
+	reacted, err := e.SignalContext(context.Background(), "product_discontinued", map[string]interface{}{"reason": "recall"}, `productId == "4711"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reacted) != 2 {
+		t.Fatal("two flows should react, got", reacted)
+	}
+	for _, f := range flows[:2] {
+		if f.Net.State[1] != 1 || f.Net.Variables["reason"] != "recall" {
+			t.Error("flow should catch the signal", f.Net.State, f.Net.Variables)
+		}
+		if err := bpnet.VerifyHistory(&f, sink.Events(f.ID)); err != nil {
+			t.Error("history should match the flow", err)
+		}
+	}
+	if flows[2].Net.State[1] != 0 {
+		t.Error("filtered flow should still wait", flows[2].Net.State)
+	}
+
+	if reacted, _ := e.Signal("customer_deleted", nil); len(reacted) != 0 {
+		t.Error("no flow waits for another signal, reacted", reacted)
+	}
+	if reacted, _ := e.Signal("product_discontinued", nil); len(reacted) != 1 || reacted[0] != flows[2].ID {
+		t.Error("signal without filter should reach the waiting flow, reacted", reacted)
+	}
+}
@@ -0,0 +1,105 @@
+package bpnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/oklog/ulid"
+)
+
+// RunningFlowIndex knows which flow of a process runs for a singleton key
+type RunningFlowIndex interface {
+	// Claim registers the flow for the key, if another flow holds the key its id is returned with claimed false
+	Claim(processName string, key string, flowID ulid.ULID) (existing ulid.ULID, claimed bool, err error)
+	// Release frees the key, only the flow holding the key can release it
+	Release(processName string, key string, flowID ulid.ULID) error
+}
+
+// SingletonConflictError is returned by Start when a flow with the same SingletonIdentifiers is running
+type SingletonConflictError struct {
+	ProcessName string
+	Identifiers map[string]interface{} // values of the SingletonIdentifiers
+	FlowID      ulid.ULID              // the running flow
+}
+
+func (e SingletonConflictError) Error() string {
+	return fmt.Sprintf("process %s already runs for %v in flow %s", e.ProcessName, e.Identifiers, e.FlowID)
+}
+
+// MemoryFlowIndex is a RunningFlowIndex in memory
+type MemoryFlowIndex struct {
+	mu    sync.Mutex
+	flows map[string]ulid.ULID // [process + key]flow
+}
+
+func NewMemoryFlowIndex() *MemoryFlowIndex {
+	return &MemoryFlowIndex{flows: make(map[string]ulid.ULID)}
+}
+
+func (i *MemoryFlowIndex) Claim(processName string, key string, flowID ulid.ULID) (ulid.ULID, bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if existing, ok := i.flows[processName+"\x00"+key]; ok && existing != flowID {
+		return existing, false, nil
+	}
+	i.flows[processName+"\x00"+key] = flowID
+	return flowID, true, nil
+}
+
+func (i *MemoryFlowIndex) Release(processName string, key string, flowID ulid.ULID) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.flows[processName+"\x00"+key] == flowID {
+		delete(i.flows, processName+"\x00"+key)
+	}
+	return nil
+}
+
+// claims the singleton key of the flow, flows of processes without SingletonIdentifiers are not checked.
+// Every identifier has to be set, flows without it would all share one key.
+func (flow *Flow) claimSingleton() error {
+	if len(flow.Process.SingletonIdentifiers) == 0 {
+		return nil
+	}
+	identifiers := make(map[string]interface{})
+	values := make([]interface{}, len(flow.Process.SingletonIdentifiers))
+	for i, id := range flow.Process.SingletonIdentifiers {
+		if flow.Net.Variables[id] == nil {
+			return fmt.Errorf("singleton identifier %s of process %s is not set", id, flow.ProcessName)
+		}
+		values[i] = flow.Net.Variables[id]
+		identifiers[id] = values[i]
+	}
+	key, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	existing, claimed, err := flow.Engine().runningFlows().Claim(flow.ProcessName, string(key), flow.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return SingletonConflictError{ProcessName: flow.ProcessName, Identifiers: identifiers, FlowID: existing}
+	}
+	flow.SingletonKey = string(key)
+	return nil
+}
+
+// releases the singleton key of a finished flow
+func (flow *Flow) releaseSingleton() {
+	if flow.SingletonKey == "" {
+		return
+	}
+	flow.Engine().runningFlows().Release(flow.ProcessName, flow.SingletonKey, flow.ID)
+	flow.SingletonKey = ""
+}
+
+func (e *Engine) runningFlows() RunningFlowIndex {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.Singletons == nil {
+		e.Singletons = NewMemoryFlowIndex()
+	}
+	return e.Singletons
+}
@@ -0,0 +1,65 @@
+package bpnet_test
+
+import (
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// test/singleton.yaml: order (user) starts a chain of auto transitions, customer is the singleton identifier
+
+func TestSingleton_Conflict(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	first := e.CreateFlow(buildfile(t, "test/singleton.yaml"), "veith")
+	if err := first.Start(map[string]interface{}{"customer": "c1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	second := e.CreateFlow(buildfile(t, "test/singleton.yaml"), "veith")
+	err := second.Start(map[string]interface{}{"customer": "c1"})
+	conflict, ok := err.(bpnet.SingletonConflictError)
+	if !ok {
+		t.Fatal("should return a SingletonConflictError, got", err)
+	}
+	if conflict.FlowID != first.ID {
+		t.Error("conflict should name the running flow", conflict.FlowID, first.ID)
+	}
+	if len(second.AvailableUserTransitions) != 0 {
+		t.Error("conflicting flow should not start")
+	}
+
+	other := e.CreateFlow(buildfile(t, "test/singleton.yaml"), "veith")
+	if err := other.Start(map[string]interface{}{"customer": "c2"}); err != nil {
+		t.Error("flows with other identifiers should start, got", err)
+	}
+}
+
+func TestSingleton_ReleasedOnCompletion(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	first := e.CreateFlow(buildfile(t, "test/singleton.yaml"), "veith")
+	first.Start(map[string]interface{}{"customer": "c1"})
+	first.Fire(0, nil)
+
+	second := e.CreateFlow(buildfile(t, "test/singleton.yaml"), "veith")
+	if err := second.Start(map[string]interface{}{"customer": "c1"}); err != nil {
+		t.Error("completed flow should release the identifiers, got", err)
+	}
+}
+
+func TestSingleton_UnsetIdentifier(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	first := e.CreateFlow(buildfile(t, "test/singleton.yaml"), "veith")
+	if err := first.Start(nil); err == nil {
+		t.Error("flow without its singleton identifier should not start")
+	}
+	if len(first.AvailableUserTransitions) != 0 {
+		t.Error("flow without its singleton identifier should not run")
+	}
+
+	// ohne prüfung teilten sich beide flows den key [null]
+	second := e.CreateFlow(buildfile(t, "test/singleton.yaml"), "veith")
+	err := second.Start(map[string]interface{}{"other": "x"})
+	if _, conflict := err.(bpnet.SingletonConflictError); err == nil || conflict {
+		t.Error("unset identifier should be refused, not conflict, got", err)
+	}
+}
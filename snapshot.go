@@ -0,0 +1,167 @@
+package bpnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// SnapshotVersion is the schema version written by MarshalSnapshot
+const SnapshotVersion = 1
+
+// Snapshot is the stored state of a flow. The process definition is not part of
+// the snapshot, RestoreFlow binds the state to a process again.
+type Snapshot struct {
+	SchemaVersion           int                         `json:"schema_version"`
+	ID                      ulid.ULID                   `json:"id"`
+	ProcessName             string                      `json:"procname"`
+	ProcessVersion          string                      `json:"procversion"`
+	ParentID                ulid.ULID                   `json:"parent_process"`
+	ParentTransitionTokenID int                         `json:"parent_transition"`
+	Owner                   string                      `json:"owner"`
+	State                   []int                       `json:"state"`
+	TokenIds                [][]int                     `json:"token_ids"`
+	TokenCounter            int                         `json:"token_counter"`
+	Variables               map[string]interface{}      `json:"variables"`
+	TransitionsInProgress   map[int]int                 `json:"in_progress"`
+	TimersDue               map[int]time.Time           `json:"timers_due"`
+	RunningSubProcesses     []ulid.ULID                 `json:"running_sub_processes"`
+	EventSeq                int                         `json:"event_seq"`
+	SingletonKey            string                      `json:"singleton_key"`
+	Assignees               map[int]string              `json:"assignees"`
+	Status                  FlowStatus                  `json:"status"`
+	CancelReason            string                      `json:"cancel_reason"`
+	Suspended               bool                        `json:"suspended"`
+	Incidents               []Incident                  `json:"incidents"`
+	Attempts                map[int]int                 `json:"attempts"`
+	Subscriptions           map[int]MessageSubscription `json:"subscriptions"`
+}
+
+// upgrades snapshots of older schema versions, indexed by the version they upgrade from
+var snapshotUpgrades = map[int]func(s *Snapshot) error{}
+
+// MarshalSnapshot writes the state of the flow as json
+func (f *Flow) MarshalSnapshot() ([]byte, error) {
+	var data []byte
+	var err error
+	f.View(func(f *Flow) {
+		data, err = json.Marshal(Snapshot{
+			SchemaVersion:           SnapshotVersion,
+			ID:                      f.ID,
+			ProcessName:             f.ProcessName,
+			ProcessVersion:          f.ProcessVersion,
+			ParentID:                f.ParentID,
+			ParentTransitionTokenID: f.ParentTransitionTokenID,
+			Owner:                   f.Owner,
+			State:                   f.Net.State,
+			TokenIds:                f.Net.TokenIds,
+			TokenCounter:            f.TokenCounter,
+			Variables:               f.Net.Variables,
+			TransitionsInProgress:   f.TransitionsInProgress,
+			TimersDue:               f.TimersDue,
+			RunningSubProcesses:     f.RunningSubProcesses,
+			EventSeq:                f.EventSeq,
+			SingletonKey:            f.SingletonKey,
+			Assignees:               f.Assignees,
+			Status:                  f.Status,
+			CancelReason:            f.CancelReason,
+			Suspended:               f.Suspended,
+			Incidents:               f.Incidents,
+			Attempts:                f.Attempts,
+			Subscriptions:           f.Subscriptions,
+		})
+	})
+	return data, err
+}
+
+// RestoreFlow reads a snapshot into a flow of the default engine
+func RestoreFlow(process Process, data []byte) (Flow, error) {
+	return defaultEngine.RestoreFlow(process, data)
+}
+
+// RestoreFlow reads a snapshot into a flow of the process, bound to this engine.
+// Variables get their declared types back. Attach the flow to drive it with the engine.
+func (e *Engine) RestoreFlow(process Process, data []byte) (Flow, error) {
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Flow{}, err
+	}
+	for s.SchemaVersion < SnapshotVersion {
+		upgrade, ok := snapshotUpgrades[s.SchemaVersion]
+		if !ok {
+			return Flow{}, fmt.Errorf("snapshot schema version %d can not be upgraded", s.SchemaVersion)
+		}
+		if err := upgrade(&s); err != nil {
+			return Flow{}, err
+		}
+		s.SchemaVersion++
+	}
+	if s.SchemaVersion > SnapshotVersion {
+		return Flow{}, fmt.Errorf("snapshot schema version %d is newer than %d", s.SchemaVersion, SnapshotVersion)
+	}
+	if s.ProcessName != process.Name {
+		return Flow{}, fmt.Errorf("snapshot of process %q can not be restored with process %q", s.ProcessName, process.Name)
+	}
+	if s.ProcessVersion != "" && s.ProcessVersion != process.Version {
+		return Flow{}, fmt.Errorf("snapshot was started with version %q of process %q, not %q", s.ProcessVersion, process.Name, process.Version)
+	}
+	if len(s.State) != len(process.InitialState) || len(s.TokenIds) != len(process.InitialState) {
+		return Flow{}, fmt.Errorf("snapshot has %d places, process %q has %d", len(s.State), process.Name, len(process.InitialState))
+	}
+
+	flow := Flow{
+		ID:                      s.ID,
+		ProcessName:             s.ProcessName,
+		ProcessVersion:          s.ProcessVersion,
+		ParentID:                s.ParentID,
+		ParentTransitionTokenID: s.ParentTransitionTokenID,
+		Owner:                   s.Owner,
+		Process:                 process,
+		TokenCounter:            s.TokenCounter,
+		TransitionsInProgress:   s.TransitionsInProgress,
+		TimersDue:               s.TimersDue,
+		RunningSubProcesses:     s.RunningSubProcesses,
+		EventSeq:                s.EventSeq,
+		SingletonKey:            s.SingletonKey,
+		Assignees:               s.Assignees,
+		Status:                  s.Status,
+		CancelReason:            s.CancelReason,
+		Suspended:               s.Suspended,
+		Incidents:               s.Incidents,
+		Attempts:                s.Attempts,
+		Subscriptions:           s.Subscriptions,
+		engine:                  e,
+		mu:                      new(sync.Mutex),
+	}
+	if flow.TransitionsInProgress == nil {
+		flow.TransitionsInProgress = make(map[int]int)
+	}
+	if flow.TimersDue == nil {
+		flow.TimersDue = make(map[int]time.Time)
+	}
+	flow.Net.InputMatrix = process.InputMatrix
+	flow.Net.OutputMatrix = process.OutputMatrix
+	flow.Net.ConditionMatrix = process.ConditionMatrix
+	flow.Net.State = s.State
+	flow.Net.TokenIds = s.TokenIds
+
+	// json kennt nur float64, string, ... die deklarierten typen wiederherstellen
+	types := make(map[string]string)
+	for _, variable := range process.Variables {
+		types[variable.ID] = variable.Type
+	}
+	flow.Net.Variables = make(map[string]interface{}, len(s.Variables))
+	for name, value := range s.Variables {
+		coerced, ok := coerceValue(types[name], value)
+		if !ok {
+			return Flow{}, fmt.Errorf("variable %s does not match type %s", name, types[name])
+		}
+		flow.Net.Variables[name] = coerced
+	}
+
+	flow.rebuildNet()
+	return flow, nil
+}
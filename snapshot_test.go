@@ -0,0 +1,65 @@
+package bpnet_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	var systemToken int
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool {
+			systemToken = tokenID
+			return true
+		},
+	})
+	process := hookProcess(t, "system")
+	process.Variables = []bpnet.Variable{{ID: "count", Type: "int"}}
+
+	f := e.CreateFlow(process, "veith")
+	if err := f.Start(map[string]interface{}{"count": 3}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := f.MarshalSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := e.RestoreFlow(process, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.Net.State, f.Net.State) || !reflect.DeepEqual(restored.Net.TokenIds, f.Net.TokenIds) {
+		t.Error("marking should be restored", restored.Net.TokenIds, f.Net.TokenIds)
+	}
+	if !reflect.DeepEqual(restored.TransitionsInProgress, f.TransitionsInProgress) || restored.TokenCounter != f.TokenCounter {
+		t.Error("transitions in progress and token counter should be restored", restored.TransitionsInProgress)
+	}
+	if restored.Net.Variables["count"] != 3 {
+		t.Errorf("variables should get their declared type back, got %T", restored.Net.Variables["count"])
+	}
+
+	// der wiederhergestellte flow muss feuern können
+	if err := restored.FireSystemTask(systemToken, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Fire(2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Net.State[3] != 1 || restored.Net.State[4] != 1 {
+		t.Error("restored flow should run to the end", restored.Net.State)
+	}
+}
+
+func TestSnapshot_Version(t *testing.T) {
+	_, err := bpnet.RestoreFlow(hookProcess(t, "system"), []byte(`{"schema_version": 99, "procname": "hooks"}`))
+	if err == nil {
+		t.Error("snapshots of an unknown schema version should be rejected")
+	}
+	_, err = bpnet.RestoreFlow(hookProcess(t, "system"), []byte(`{"schema_version": 1, "procname": "other"}`))
+	if err == nil {
+		t.Error("snapshots of another process should be rejected")
+	}
+}
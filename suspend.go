@@ -0,0 +1,98 @@
+package bpnet
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// errors of Suspend, Resume and of fires on a suspended flow
+var (
+	ErrFlowSuspended    = errors.New("flow is suspended")
+	ErrFlowNotSuspended = errors.New("flow is not suspended")
+)
+
+// Suspend freezes a running flow, see SuspendContext
+func (f *Flow) Suspend() error {
+	return f.SuspendContext(context.Background())
+}
+
+// SuspendContext freezes a running flow. Fire returns ErrFlowSuspended, AUTO and MESSAGE
+// transitions do not fire and no timers, system tasks or subflows are started.
+// Timers which become due are deferred to Resume. System tasks and subflows already
+// in progress can still complete. OnSuspensionChanged is called.
+func (f *Flow) SuspendContext(ctx context.Context) error {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	if err := f.checkRunning(); err != nil {
+		return err
+	}
+	if f.Suspended {
+		return ErrFlowSuspended
+	}
+	f.Suspended = true
+	f.record(Event{Type: EventSuspended, Actor: actorID(ctx, f.Owner)})
+	return f.Engine().notify(ctx, HookSuspensionChanged, f, -1)
+}
+
+// Resume continues a suspended flow, see ResumeContext
+func (f *Flow) Resume() error {
+	return f.ResumeContext(context.Background())
+}
+
+// ResumeContext continues a suspended flow. The timers which became due fire in order of
+// their due time, then the held back transitions are triggered.
+func (f *Flow) ResumeContext(ctx context.Context) error {
+	ctx, unlock := f.lock(ctx)
+	defer unlock()
+	if err := f.checkRunning(); err != nil {
+		return err
+	}
+	if !f.Suspended {
+		return ErrFlowNotSuspended
+	}
+	e := f.Engine()
+	f.Suspended = false
+	f.record(Event{Type: EventResumed, Actor: actorID(ctx, f.Owner)})
+	err := e.notify(ctx, HookSuspensionChanged, f, -1)
+
+	// aufgelaufene timer in der reihenfolge ihrer fälligkeit feuern
+	now := e.clock().Now()
+	var due []int
+	for tokenID, at := range f.TimersDue {
+		if !at.After(now) {
+			due = append(due, tokenID)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		a, b := f.TimersDue[due[i]], f.TimersDue[due[j]]
+		if a.Equal(b) {
+			return due[i] < due[j]
+		}
+		return a.Before(b)
+	})
+	for _, tokenID := range due {
+		err = firstError(err, f.fireTimer(ctx, tokenID))
+	}
+
+	// der check stellt auch nachrichten zu, die während der pause gepuffert wurden
+	if f.Status == StatusRunning || f.Status == StatusFailed {
+		var checkErr error
+		f.AvailableUserTransitions, checkErr = f.bpnTransitionsCheck(ctx)
+		err = firstError(err, checkErr)
+	}
+	return err
+}
+
+// only a started flow which has not ended can be suspended
+func (f *Flow) checkRunning() error {
+	switch f.Status {
+	case StatusRunning, StatusFailed:
+		return nil
+	case StatusCompleted:
+		return ErrFlowCompleted
+	case StatusCancelled:
+		return ErrFlowCancelled
+	}
+	return errors.New("flow is not started")
+}
@@ -0,0 +1,161 @@
+package bpnet_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/veith/bpnet"
+)
+
+// test/suspend.yaml: p0 -> split (auto) -> p1 + p2, p1 -> slow (timed 2m) -> p3, p2 -> fast (timed 1m) -> p4
+
+func TestSuspend_DefersTimers(t *testing.T) {
+	clock := newManualClock()
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.Clock = clock
+	e.History = sink
+
+	f := e.CreateFlow(buildfile(t, "test/suspend.yaml"), "veith")
+	f.Start(nil)
+	if err := f.Suspend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Suspend(); !errors.Is(err, bpnet.ErrFlowSuspended) {
+		t.Error("second suspend should fail, got", err)
+	}
+	if err := f.Fire(0, nil); !errors.Is(err, bpnet.ErrFlowSuspended) {
+		t.Error("fire of a suspended flow should fail, got", err)
+	}
+
+	clock.Advance(3 * time.Minute)
+	if f.Net.State[3] != 0 || f.Net.State[4] != 0 {
+		t.Fatal("timers of a suspended flow should not fire", f.Net.State)
+	}
+	if timers, _ := e.Timers.List(); len(timers) != 2 {
+		t.Error("deferred timers should stay in the store", timers)
+	}
+	snapshot, _ := f.MarshalSnapshot()
+	if !strings.Contains(string(snapshot), `"suspended":true`) {
+		t.Error("snapshot should contain the suspension", string(snapshot))
+	}
+
+	if err := f.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[3] != 1 || f.Net.State[4] != 1 {
+		t.Error("due timers should fire on resume", f.Net.State)
+	}
+	var fired []string
+	for _, event := range sink.Events(f.ID) {
+		if event.Type == bpnet.EventTimerFired {
+			fired = append(fired, event.TransitionID)
+		}
+	}
+	if strings.Join(fired, ",") != "fast,slow" {
+		t.Error("timers should fire in order of their due time, fired", fired)
+	}
+	if err := bpnet.VerifyHistory(&f, sink.Events(f.ID)); err != nil {
+		t.Error("history should match the flow", err)
+	}
+}
+
+func TestSuspend_HoldsBackAutofire(t *testing.T) {
+	var changes []bool
+	e := bpnet.NewEngine(nil)
+	e.Hooks = &bpnet.HandlerV2{
+		OnSystemTask: func(ctx context.Context, flow *bpnet.Flow, tokenID int, transitionIndex int) error {
+			return nil
+		},
+		OnSuspensionChanged: func(ctx context.Context, flow *bpnet.Flow, transitionIndex int) error {
+			changes = append(changes, flow.Suspended)
+			return nil
+		},
+	}
+	// p0 -> call (system) -> p1 -> done (auto) -> p2
+	f := e.CreateFlow(bpnet.Process{
+		Name:            "held",
+		InputMatrix:     [][]int{{1, 0, 0}, {0, 1, 0}},
+		OutputMatrix:    [][]int{{0, 1, 0}, {0, 0, 1}},
+		InitialState:    []int{1, 0, 0},
+		TransitionTypes: []int{6, 1},
+		Transitions:     []bpnet.Transition{{ID: "call"}, {ID: "done"}},
+	}, "veith")
+	if err := f.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Suspend()
+	if err := f.FireSystemTask(1, nil); err != nil {
+		t.Fatal("system task in progress should complete while suspended", err)
+	}
+	if f.Net.State[1] != 1 {
+		t.Error("auto transition should be held back", f.Net.State)
+	}
+	if err := f.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[2] != 1 {
+		t.Error("auto transition should fire on resume", f.Net.State)
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Error("OnSuspensionChanged should report suspend and resume, got", changes)
+	}
+	if err := f.Resume(); !errors.Is(err, bpnet.ErrFlowCompleted) {
+		t.Error("resume of a completed flow should fail, got", err)
+	}
+}
+
+func TestSuspend_FailedFlowDefersTimers(t *testing.T) {
+	clock := newManualClock()
+	e := bpnet.NewEngine(&bpnet.Handler{
+		OnSystemTask: func(flow *bpnet.Flow, tokenID int, transitionIndex int) bool { return true },
+	})
+	e.Clock = clock
+
+	// slow wird zum system task, dessen incident den flow auf failed setzt
+	process := buildfile(t, "test/suspend.yaml", func(net *bpnet.ImportNet) {
+		net.Transition[1].TransitionType = "system"
+		net.Transition[1].Details = nil
+	})
+	f := e.CreateFlow(process, "veith")
+	f.Start(nil)
+	if err := f.FailSystemTask(2, errors.New("down")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Suspend(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != bpnet.StatusFailed {
+		t.Fatal("flow should be failed", f.Status)
+	}
+
+	clock.Advance(3 * time.Minute)
+	if f.Net.State[4] != 0 {
+		t.Fatal("timers of a suspended failed flow should not fire", f.Net.State)
+	}
+	if err := f.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Net.State[4] != 1 {
+		t.Error("due timer should fire on resume", f.Net.State)
+	}
+}
+
+func TestSuspend_HoldsBackTasks(t *testing.T) {
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(buildfile(t, "test/worklist.yaml"), "veith")
+	f.Start(nil)
+	f.Suspend()
+	if tasks := f.Tasks(); len(tasks) != 0 {
+		t.Error("suspended flow should have no tasks", tasks)
+	}
+	if err := f.Claim(2, anna); !errors.Is(err, bpnet.ErrFlowSuspended) {
+		t.Error("claim on a suspended flow should fail, got", err)
+	}
+	f.Resume()
+	if err := f.Claim(2, anna); err != nil || len(f.Tasks()) != 2 {
+		t.Error("tasks should come back on resume", err, f.Tasks())
+	}
+}
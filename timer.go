@@ -0,0 +1,270 @@
+package bpnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// Clock is the time source of an engine. Replace it in tests to control timers.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is an armed timer of a Clock
+type Timer interface {
+	Stop() bool
+}
+
+// clock based on the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// ScheduledTimer is the timer of a token in a TIMED transition
+type ScheduledTimer struct {
+	FlowID     ulid.ULID `json:"flow"`       // flow of the token
+	TokenID    int       `json:"token"`      // token waiting in the transition
+	Transition int       `json:"transition"` // index of the TIMED transition or of a SYSTEM transition waiting for a retry
+	Due        time.Time `json:"due"`        // time to fire
+}
+
+// TimerStore keeps the due times of all armed timers, so they survive a restart
+type TimerStore interface {
+	Schedule(timer ScheduledTimer) error
+	Cancel(flowID ulid.ULID, tokenID int) error
+	Due(until time.Time) ([]ScheduledTimer, error) // timers due until the given time, ordered by due time
+	List() ([]ScheduledTimer, error)               // all timers, ordered by due time
+}
+
+type timerKey struct {
+	FlowID  ulid.ULID
+	TokenID int
+}
+
+// MemoryTimerStore keeps the timers in memory
+type MemoryTimerStore struct {
+	mu     sync.Mutex
+	timers map[timerKey]ScheduledTimer
+}
+
+func NewMemoryTimerStore() *MemoryTimerStore {
+	return &MemoryTimerStore{timers: make(map[timerKey]ScheduledTimer)}
+}
+
+func (s *MemoryTimerStore) Schedule(timer ScheduledTimer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timers[timerKey{timer.FlowID, timer.TokenID}] = timer
+	return nil
+}
+
+func (s *MemoryTimerStore) Cancel(flowID ulid.ULID, tokenID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.timers, timerKey{flowID, tokenID})
+	return nil
+}
+
+func (s *MemoryTimerStore) Due(until time.Time) ([]ScheduledTimer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return dueTimers(s.timers, until), nil
+}
+
+func (s *MemoryTimerStore) List() ([]ScheduledTimer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedTimers(s.timers), nil
+}
+
+// FileTimerStore keeps the timers in a json file, the file is rewritten on every change
+type FileTimerStore struct {
+	mu     sync.Mutex
+	path   string
+	timers map[timerKey]ScheduledTimer
+}
+
+// Opens or creates a file based timer store
+func NewFileTimerStore(path string) (*FileTimerStore, error) {
+	s := &FileTimerStore{path: path, timers: make(map[timerKey]ScheduledTimer)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var timers []ScheduledTimer
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &timers); err != nil {
+			return nil, err
+		}
+	}
+	for _, timer := range timers {
+		s.timers[timerKey{timer.FlowID, timer.TokenID}] = timer
+	}
+	return s, nil
+}
+
+func (s *FileTimerStore) Schedule(timer ScheduledTimer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timers[timerKey{timer.FlowID, timer.TokenID}] = timer
+	return s.write()
+}
+
+func (s *FileTimerStore) Cancel(flowID ulid.ULID, tokenID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.timers[timerKey{flowID, tokenID}]; !ok {
+		return nil
+	}
+	delete(s.timers, timerKey{flowID, tokenID})
+	return s.write()
+}
+
+func (s *FileTimerStore) Due(until time.Time) ([]ScheduledTimer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return dueTimers(s.timers, until), nil
+}
+
+func (s *FileTimerStore) List() ([]ScheduledTimer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedTimers(s.timers), nil
+}
+
+// writes to a temp file and renames it, so a crash never leaves a half written file
+func (s *FileTimerStore) write() error {
+	b, err := json.Marshal(sortedTimers(s.timers))
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func sortedTimers(timers map[timerKey]ScheduledTimer) []ScheduledTimer {
+	list := make([]ScheduledTimer, 0, len(timers))
+	for _, timer := range timers {
+		list = append(list, timer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Due.Equal(list[j].Due) {
+			return list[i].TokenID < list[j].TokenID
+		}
+		return list[i].Due.Before(list[j].Due)
+	})
+	return list
+}
+
+func dueTimers(timers map[timerKey]ScheduledTimer, until time.Time) []ScheduledTimer {
+	var due []ScheduledTimer
+	for _, timer := range sortedTimers(timers) {
+		if timer.Due.After(until) {
+			break
+		}
+		due = append(due, timer)
+	}
+	return due
+}
+
+// RecoverTimers re-arms the timers of the TimerStore after a restart.
+// The flows are loaded via FlowInstanceLoader, overdue timers fire at once.
+// The loader has to restore the flows with RestoreFlow or LoadSnapshot.
+func (e *Engine) RecoverTimers() error {
+	timers, err := e.timerStore().List()
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	now := e.clock().Now()
+	for _, timer := range timers {
+		flow, err := e.loadFlow(context.Background(), timer.FlowID)
+		if err != nil || flow == nil {
+			if firstErr == nil {
+				firstErr = errors.New("timer of flow " + timer.FlowID.String() + " could not be recovered")
+			}
+			continue
+		}
+		e.Attach(flow)
+		// fehler der hooks ändern nichts am gefeuerten timer
+		firstErr = firstError(firstErr, e.recoverTimer(flow, timer, now))
+	}
+	return firstErr
+}
+
+// restores a timer on its flow and fires or arms it
+func (e *Engine) recoverTimer(flow *Flow, timer ScheduledTimer, now time.Time) error {
+	ctx, unlock := flow.lock(context.Background())
+	defer unlock()
+	if flow.TransitionsInProgress == nil {
+		flow.TransitionsInProgress = make(map[int]int)
+	}
+	if flow.TimersDue == nil {
+		flow.TimersDue = make(map[int]time.Time)
+	}
+	flow.TransitionsInProgress[timer.TokenID] = timer.Transition
+	flow.TimersDue[timer.TokenID] = timer.Due
+
+	if !timer.Due.After(now) {
+		return flow.fireTimer(ctx, timer.TokenID)
+	}
+	e.armTimer(flow, timer.TokenID, timer.Due)
+	return nil
+}
+
+// arms the timer of a token on the engine clock, a timer is armed only once
+func (e *Engine) armTimer(f *Flow, tokenID int, due time.Time) {
+	key := timerKey{f.ID, tokenID}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.armed == nil {
+		e.armed = make(map[timerKey]Timer)
+	}
+	if _, ok := e.armed[key]; ok {
+		return
+	}
+	e.armed[key] = e.clock().AfterFunc(due.Sub(e.clock().Now()), func() {
+		e.mu.Lock()
+		delete(e.armed, key)
+		e.mu.Unlock()
+		if err := f.fireTimer(context.Background(), tokenID); err != nil && e.OnTimerError != nil {
+			e.OnTimerError(f, tokenID, err)
+		}
+	})
+}
+
+func (e *Engine) clock() Clock {
+	if e.Clock == nil {
+		return realClock{}
+	}
+	return e.Clock
+}
+
+func (e *Engine) timerStore() TimerStore {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.Timers == nil {
+		e.Timers = NewMemoryTimerStore()
+	}
+	return e.Timers
+}
@@ -0,0 +1,285 @@
+package bpnet_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/veith/bpnet"
+)
+
+// manualClock only moves forward on Advance
+type manualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock   *manualClock
+	due     time.Time
+	f       func()
+	stopped bool
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Date(2023, 3, 15, 10, 0, 0, 0, time.UTC)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) bpnet.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTimer{clock: c, due: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+// moves the clock and runs the expired timers in order of their due time
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].due.Before(c.timers[j].due) })
+		if len(c.timers) == 0 || c.timers[0].due.After(c.now) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.stopped {
+			continue
+		}
+		t.stopped = true
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.mu.Unlock()
+}
+
+func timedProcess(delay interface{}) bpnet.Process {
+	process := freshProcess()
+	process.InputMatrix = [][]int{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+	}
+	process.OutputMatrix = [][]int{
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+	process.InitialState = []int{1, 0, 0, 0}
+	process.TransitionTypes = []int{1, 4, 2}
+	process.Transitions = make([]bpnet.Transition, 3)
+	process.Transitions[1].Details = map[string]interface{}{"delay": delay}
+	return process
+}
+
+func TestTimer_ManualClock(t *testing.T) {
+	clock := newManualClock()
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.Clock = clock
+
+	f := e.CreateFlow(timedProcess(60), "veith")
+	f.Start(nil)
+
+	timers, _ := e.Timers.List()
+	if len(timers) != 1 || !timers[0].Due.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatal("timer should be scheduled in a minute, is", timers)
+	}
+	if !f.TimersDue[timers[0].TokenID].Equal(timers[0].Due) {
+		t.Error("flow should know the due time of its timer")
+	}
+
+	clock.Advance(59 * time.Second)
+	if f.Net.State[2] != 0 {
+		t.Error("timer should not fire before it is due")
+	}
+	clock.Advance(time.Second)
+	if f.Net.State[2] != 1 {
+		t.Error("timer should have fired", f.Net.State)
+	}
+	if timers, _ := e.Timers.List(); len(timers) != 0 {
+		t.Error("fired timer should be removed from the store", timers)
+	}
+}
+
+func TestTimer_RecoverAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timers.json")
+	store, err := bpnet.NewFileTimerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newManualClock()
+	before := bpnet.NewEngine(&bpnet.Handler{})
+	before.Clock = clock
+	before.Timers = store
+
+	a := before.CreateFlow(timedProcess(60), "veith")
+	a.Start(nil)
+	b := before.CreateFlow(timedProcess(3600), "veith")
+	b.Start(nil)
+
+	// service restarts, flows come from storage
+	snapshots := make(map[string][]byte)
+	processes := map[string]bpnet.Process{a.ID.String(): a.Process, b.ID.String(): b.Process}
+	for _, f := range []bpnet.Flow{a, b} {
+		data, err := f.MarshalSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots[f.ID.String()] = data
+	}
+	reopened, err := bpnet.NewFileTimerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterClock := &manualClock{now: clock.Now().Add(10 * time.Minute)}
+	restored := make(map[string]*bpnet.Flow)
+	var after *bpnet.Engine
+	after = bpnet.NewEngine(&bpnet.Handler{
+		FlowInstanceLoader: func(flowID ulid.ULID) (*bpnet.Flow, error) {
+			f, err := after.RestoreFlow(processes[flowID.String()], snapshots[flowID.String()])
+			restored[flowID.String()] = &f
+			return &f, err
+		},
+	})
+	after.Clock = afterClock
+	after.Timers = reopened
+
+	if err := after.RecoverTimers(); err != nil {
+		t.Fatal(err)
+	}
+	overdue, pending := restored[a.ID.String()], restored[b.ID.String()]
+	if overdue.Net.State[2] != 1 {
+		t.Error("overdue timer should fire on recovery", overdue.Net.State)
+	}
+	if pending.Net.State[2] != 0 {
+		t.Error("pending timer should not fire on recovery", pending.Net.State)
+	}
+	afterClock.Advance(time.Hour)
+	if pending.Net.State[2] != 1 {
+		t.Error("recovered timer should fire when due", pending.Net.State)
+	}
+	if timers, _ := reopened.List(); len(timers) != 0 {
+		t.Error("all timers should be done", timers)
+	}
+}
+
+func TestMemoryTimerStore_Due(t *testing.T) {
+	store := bpnet.NewMemoryTimerStore()
+	now := time.Now()
+	store.Schedule(bpnet.ScheduledTimer{TokenID: 1, Due: now.Add(time.Hour)})
+	store.Schedule(bpnet.ScheduledTimer{TokenID: 2, Due: now.Add(-time.Hour)})
+	store.Schedule(bpnet.ScheduledTimer{TokenID: 3, Due: now})
+
+	due, _ := store.Due(now)
+	if len(due) != 2 || due[0].TokenID != 2 || due[1].TokenID != 3 {
+		t.Error("should list the due timers in order", due)
+	}
+	store.Cancel(ulid.ULID{}, 2)
+	if due, _ := store.Due(now); len(due) != 1 {
+		t.Error("cancelled timer should not be due", due)
+	}
+}
+
+// a file store in a directory which does not exist, every write fails
+func brokenTimerStore(t *testing.T) *bpnet.FileTimerStore {
+	store, err := bpnet.NewFileTimerStore(filepath.Join(t.TempDir(), "missing", "timers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestTimer_StoreFails(t *testing.T) {
+	clock := newManualClock()
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.Clock = clock
+	e.Timers = brokenTimerStore(t)
+
+	f := e.CreateFlow(timedProcess(60), "veith")
+	if err := f.Start(nil); err == nil {
+		t.Fatal("timer which can not be stored should be reported")
+	}
+	if len(f.TransitionsInProgress) != 0 || len(f.TimersDue) != 0 {
+		t.Error("token should not wait for a timer which is not stored", f.TransitionsInProgress, f.TimersDue)
+	}
+	clock.Advance(time.Hour)
+	if f.Net.State[2] != 0 {
+		t.Error("timer should not fire", f.Net.State)
+	}
+}
+
+func TestTimer_RecoverUnrestoredFlow(t *testing.T) {
+	store := bpnet.NewMemoryTimerStore()
+	before := bpnet.NewEngine(&bpnet.Handler{})
+	before.Clock = newManualClock()
+	before.Timers = store
+	f := before.CreateFlow(timedProcess(60), "veith")
+	f.Start(nil)
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := bpnet.NewEngine(&bpnet.Handler{
+		FlowInstanceLoader: func(flowID ulid.ULID) (*bpnet.Flow, error) {
+			var loaded bpnet.Flow
+			return &loaded, json.Unmarshal(data, &loaded)
+		},
+	})
+	after.Timers = store
+	if err := after.RecoverTimers(); err == nil {
+		t.Error("flow without a net should not be recovered")
+	}
+}
+
+func TestTimer_OnTimerError(t *testing.T) {
+	clock := newManualClock()
+	var failed []int
+	e := bpnet.NewEngine(nil)
+	e.Clock = clock
+	e.Hooks = &bpnet.HandlerV2{
+		OnTimerCompleted: func(ctx context.Context, flow *bpnet.Flow, transitionIndex int) error {
+			return errors.New("mail server down")
+		},
+	}
+	f := e.CreateFlow(timedProcess(60), "veith")
+	e.OnTimerError = func(flow *bpnet.Flow, tokenID int, err error) {
+		if flow.ID != f.ID || err == nil {
+			t.Error("OnTimerError should get the flow and the error", flow.ID, err)
+		}
+		failed = append(failed, tokenID)
+	}
+	f.Start(nil)
+	timers, _ := e.Timers.List()
+	if len(timers) != 1 {
+		t.Fatal("timer should be scheduled, have", timers)
+	}
+
+	clock.Advance(time.Minute)
+	if len(failed) != 1 || failed[0] != timers[0].TokenID {
+		t.Error("error of the fired timer should go to OnTimerError, got", failed)
+	}
+}
@@ -0,0 +1,103 @@
+package bpnet
+
+// The net numbers new tokens with a counter shared by all flows, which starts again with
+// every Init. Tokens created by the net get the next id of Flow.TokenCounter instead,
+// so the ids are unique within a flow and survive a reload from json.
+
+// the next token id of the flow
+func (f *Flow) nextTokenID() int {
+	if f.TokenCounter == 0 {
+		// flows stored without a counter continue after their highest token
+		f.TokenCounter = f.highestTokenID()
+	}
+	f.TokenCounter++
+	return f.TokenCounter
+}
+
+func (f *Flow) highestTokenID() int {
+	highest := 0
+	for _, ids := range f.Net.TokenIds {
+		for _, id := range ids {
+			highest = max(highest, id)
+		}
+	}
+	for id := range f.TransitionsInProgress {
+		highest = max(highest, id)
+	}
+	return highest
+}
+
+// places the initial tokens, numbered from 1
+func (f *Flow) netInit() {
+	netMu.Lock()
+	f.Net.Init()
+	netMu.Unlock()
+
+	f.TokenCounter = 0
+	for _, ids := range f.Net.TokenIds {
+		for i := range ids {
+			f.TokenCounter++
+			ids[i] = f.TokenCounter
+		}
+	}
+}
+
+// compiles the conditions and evaluates the enabled transitions of a restored
+// marking, the token ids and the counter are kept
+func (f *Flow) rebuildNet() {
+	tokenIds, counter := f.Net.TokenIds, f.TokenCounter
+	f.netInit()
+	f.Net.TokenIds, f.TokenCounter = tokenIds, counter
+	f.AvailableUserTransitions = f.Net.EnabledTransitions
+}
+
+func (f *Flow) netFire(transitionIndex int) error {
+	output, err := f.outputRow(transitionIndex)
+	if err != nil {
+		return err
+	}
+	return f.netFireOutput(transitionIndex, 0, output)
+}
+
+func (f *Flow) netFireWithTokenId(transitionIndex int, tokenID int) error {
+	output, err := f.outputRow(transitionIndex)
+	if err != nil {
+		return err
+	}
+	return f.netFireOutput(transitionIndex, tokenID, output)
+}
+
+// fires with another output row of the transition, nil keeps the output arcs.
+// tokenID 0 fires the front tokens.
+func (f *Flow) netFireOutput(transitionIndex int, tokenID int, output []int) error {
+	if output != nil {
+		matrix := f.Net.OutputMatrix
+		rows := make([][]int, len(matrix))
+		copy(rows, matrix)
+		rows[transitionIndex] = output
+		f.Net.OutputMatrix = rows
+		defer func() { f.Net.OutputMatrix = matrix }()
+	}
+	netMu.Lock()
+	var err error
+	if tokenID == 0 {
+		err = f.Net.Fire(transitionIndex)
+	} else {
+		err = f.Net.FireWithTokenId(transitionIndex, tokenID)
+	}
+	netMu.Unlock()
+	if err == nil {
+		f.numberProduced(transitionIndex)
+	}
+	return err
+}
+
+// the net appends the produced tokens to the end of the output places
+func (f *Flow) numberProduced(transitionIndex int) {
+	for place, step := range f.Net.OutputMatrix[transitionIndex] {
+		ids := f.Net.TokenIds[place]
+		for i := len(ids) - step; i < len(ids); i++ {
+			ids[i] = f.nextTokenID()
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package bpnet_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// test/tokens.yaml: p0 (3 tokens) -> take (user) -> p1
+
+func TestTokens_UniqueAcrossFlows(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	a := e.CreateFlow(buildfile(t, "test/tokens.yaml"), "veith")
+	a.Start(nil)
+	a.Fire(0, nil)
+	a.Fire(0, nil)
+
+	// ein weiterer start darf die ids von a nicht wiederholen
+	b := e.CreateFlow(buildfile(t, "test/tokens.yaml"), "veith")
+	b.Start(nil)
+	if err := a.Fire(0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := a.Net.TokenIds[1]; len(ids) != 3 || ids[0] != 4 || ids[1] != 5 || ids[2] != 6 {
+		t.Error("tokens of a flow should be numbered by the flow, got", ids)
+	}
+	if a.TokenCounter != 6 || b.TokenCounter != 3 {
+		t.Error("each flow should count its own tokens", a.TokenCounter, b.TokenCounter)
+	}
+}
+
+func TestTokens_CounterSurvivesJSON(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	f := e.CreateFlow(buildfile(t, "test/tokens.yaml"), "veith")
+	f.Start(nil)
+	f.Fire(0, nil)
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded bpnet.Flow
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.TokenCounter != 4 {
+		t.Error("token counter should be stored with the flow, got", loaded.TokenCounter)
+	}
+}
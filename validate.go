@@ -0,0 +1,234 @@
+package bpnet
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/antonmedv/expr"
+)
+
+// ValidationIssue is a problem with one element of an ImportNet
+type ValidationIssue struct {
+	Path    string      `json:"path"`    // position in the yaml, e.g. arcs[3]
+	Element interface{} `json:"element"` // the yaml element (Place, Transition, Arc, Variable or start variable)
+	Reason  string      `json:"reason"`
+}
+
+// ValidationError lists all issues found in an ImportNet
+type ValidationError struct {
+	Issues []ValidationIssue
+}
+
+func (e ValidationError) Len() int {
+	return len(e.Issues)
+}
+
+func (e ValidationError) Error() string {
+	reasons := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		reasons[i] = issue.Path + ": " + issue.Reason
+	}
+	return "invalid process: " + strings.Join(reasons, "; ")
+}
+
+func (e *ValidationError) add(path string, element interface{}, reason string, args ...interface{}) {
+	e.Issues = append(e.Issues, ValidationIssue{Path: path, Element: element, Reason: fmt.Sprintf(reason, args...)})
+}
+
+// ValidateImportNet checks an ImportNet and reports every issue found
+func ValidateImportNet(yamlstruct ImportNet) ValidationError {
+	var report ValidationError
+
+	places := make(map[string]bool)
+	for i, place := range yamlstruct.Place {
+		path := fmt.Sprintf("places[%d]", i)
+		if place.ID == "" {
+			report.add(path, place, "place without id")
+		} else if places[place.ID] {
+			report.add(path, place, "duplicate place id %q", place.ID)
+		}
+		places[place.ID] = true
+		if place.Tokens < 0 {
+			report.add(path, place, "negative tokens %d", place.Tokens)
+		}
+		if place.Choice != "" && place.Choice != ChoiceFirst && place.Choice != ChoiceStrict {
+			report.add(path, place, "unknown choice policy %q, expected %s or %s", place.Choice, ChoiceFirst, ChoiceStrict)
+		}
+	}
+
+	variables := make(map[string]bool)
+	for i, variable := range yamlstruct.Variables {
+		path := fmt.Sprintf("variables[%d]", i)
+		if variable.ID == "" {
+			report.add(path, variable, "variable without id")
+		} else if variables[variable.ID] {
+			report.add(path, variable, "duplicate variable id %q", variable.ID)
+		}
+		variables[variable.ID] = true
+		if !knownType(variable.Type) {
+			report.add(path, variable, "unknown variable type %q", variable.Type)
+		}
+	}
+	for i, required := range yamlstruct.StartVariables {
+		if !variables[required] {
+			report.add(fmt.Sprintf("startvariables[%d]", i), required, "start variable %q is not declared", required)
+		}
+	}
+
+	for i, identifier := range yamlstruct.SingletonIdentifiers {
+		if !variables[identifier] {
+			report.add(fmt.Sprintf("singletonidentifiers[%d]", i), identifier, "singleton identifier %q is not declared", identifier)
+		}
+	}
+
+	transitions := make(map[string]bool)
+	systemTasks := make(map[string]bool)
+	for i, transition := range yamlstruct.Transition {
+		path := fmt.Sprintf("transitions[%d]", i)
+		if transition.ID == "" {
+			report.add(path, transition, "transition without id")
+		} else if transitions[transition.ID] {
+			report.add(path, transition, "duplicate transition id %q", transition.ID)
+		}
+		transitions[transition.ID] = true
+
+		switch transitionType(transition.TransitionType) {
+		case SYSTEM:
+			systemTasks[transition.ID] = true
+			if _, err := parseRetryPolicy(transition.Details["retry"]); err != nil {
+				report.add(path, transition, "%v", err)
+			}
+		case 0:
+			report.add(path, transition, "unknown transition type %q", transition.TransitionType)
+		case RECEIVE:
+			if name, _ := transition.Details["message"].(string); name == "" {
+				report.add(path, transition, "receive transition without message name in details.message")
+			}
+			if err := validateCorrelation(transition.Details["correlation"]); err != nil {
+				report.add(path, transition, "%v", err)
+			}
+		case SIGNAL:
+			if name, _ := transition.Details["signal"].(string); name == "" {
+				report.add(path, transition, "signal transition without signal name in details.signal")
+			}
+		case SUBPROCESS:
+			if name, _ := transition.Details["process"].(string); name == "" {
+				report.add(path, transition, "subprocess transition without process name in details.process")
+			}
+		case TIMED:
+			if err := validateDelay(transition.Details["delay"]); err != nil {
+				report.add(path, transition, "%v", err)
+			}
+		}
+		if split, ok := transition.Details["split"]; ok && split != SplitOR && split != SplitXOR {
+			report.add(path, transition, "unknown split %v, expected %s or %s", split, SplitOR, SplitXOR)
+		}
+		for _, required := range transition.ReqVariables {
+			if !variables[required] {
+				report.add(path, transition, "required variable %q is not declared", required)
+			}
+		}
+	}
+
+	env := variableEnv(yamlstruct.Variables)
+	defaults := make(map[string]bool)
+	for i, arc := range yamlstruct.Arc {
+		path := fmt.Sprintf("arcs[%d]", i)
+		if arc.Type != "pt" && (arc.Priority != 0 || arc.Default) {
+			report.add(path, arc, "priority and default are only allowed on pt arcs")
+		}
+		switch arc.Type {
+		case "pt":
+			if arc.Default {
+				if arc.Condition != "" {
+					report.add(path, arc, "default arc with condition %q", arc.Condition)
+				}
+				if defaults[arc.Source] {
+					report.add(path, arc, "second default arc of place %q", arc.Source)
+				}
+				defaults[arc.Source] = true
+			}
+			if !places[arc.Source] {
+				report.add(path, arc, "source %q is not a place", arc.Source)
+			}
+			if !transitions[arc.Destination] {
+				report.add(path, arc, "destination %q is not a transition", arc.Destination)
+			}
+			if arc.Condition != "" {
+				if _, err := expr.Compile(arc.Condition, expr.Env(env), expr.AsBool()); err != nil {
+					report.add(path, arc, "condition %q: %v", arc.Condition, err)
+				}
+			}
+		case "tp":
+			if !transitions[arc.Source] {
+				report.add(path, arc, "source %q is not a transition", arc.Source)
+			}
+			if !places[arc.Destination] {
+				report.add(path, arc, "destination %q is not a place", arc.Destination)
+			}
+			if arc.Condition != "" {
+				if _, err := expr.Compile(arc.Condition, expr.Env(env), expr.AsBool()); err != nil {
+					report.add(path, arc, "condition %q: %v", arc.Condition, err)
+				}
+			}
+		case "error":
+			if !systemTasks[arc.Source] {
+				report.add(path, arc, "source %q of an error arc is not a system transition", arc.Source)
+			}
+			if !places[arc.Destination] {
+				report.add(path, arc, "destination %q is not a place", arc.Destination)
+			}
+		default:
+			report.add(path, arc, "unknown arc type %q, expected pt, tp or error", arc.Type)
+		}
+		if arc.Weight < 0 {
+			report.add(path, arc, "negative weight %d", arc.Weight)
+		}
+		if arc.Priority < 0 {
+			report.add(path, arc, "negative priority %d", arc.Priority)
+		}
+	}
+
+	return report
+}
+
+// builds an environment with the declared variable types to type check conditions,
+// untyped variables are interface{}
+func variableEnv(variables []Variable) interface{} {
+	var fields []reflect.StructField
+	seen := make(map[string]bool)
+	for i, variable := range variables {
+		if variable.ID == "" || seen[variable.ID] {
+			continue
+		}
+		seen[variable.ID] = true
+		fields = append(fields, reflect.StructField{
+			Name: fmt.Sprintf("V%d", i),
+			Type: variableType(variable.Type),
+			Tag:  reflect.StructTag(`expr:"` + variable.ID + `"`),
+		})
+	}
+	return reflect.New(reflect.StructOf(fields)).Elem().Interface()
+}
+
+func variableType(name string) reflect.Type {
+	switch canonicalType(name) {
+	case TypeInt:
+		return reflect.TypeOf(0)
+	case TypeFloat:
+		return reflect.TypeOf(0.0)
+	case TypeString:
+		return reflect.TypeOf("")
+	case TypeBool:
+		return reflect.TypeOf(false)
+	case TypeTime:
+		return reflect.TypeOf(time.Time{})
+	case TypeList:
+		return reflect.TypeOf([]interface{}{})
+	case TypeObject:
+		return reflect.TypeOf(map[string]interface{}{})
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
@@ -0,0 +1,135 @@
+package bpnet_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/veith/bpnet"
+)
+
+const brokenNet = `
+title: broken
+transitions:
+  - id: approve
+    type: user
+    variables:
+      - comment
+  - id: approve
+    type: auto
+  - id: sub
+    type: subprocess
+  - id: mystery
+    type: teleport
+variables:
+  - id: counts
+    type: int
+startvariables:
+  - counts
+places:
+  - id: start
+    tokens: -1
+  - id: start
+  - id: end
+arcs:
+  - sourceId: start
+    destinationId: nowhere
+    type: pt
+  - sourceId: approve
+    destinationId: end
+    type: tp
+    weight: -2
+  - sourceId: start
+    destinationId: approve
+    type: xy
+  - sourceId: start
+    destinationId: sub
+    type: pt
+    condition: counts > "five"
+`
+
+func TestValidateImportNet_Report(t *testing.T) {
+	var yamlstruct bpnet.ImportNet
+	if err := yaml.Unmarshal([]byte(brokenNet), &yamlstruct); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := bpnet.BuildProcessFromYaml(yamlstruct)
+	report, ok := err.(bpnet.ValidationError)
+	if !ok {
+		t.Fatal("should return a ValidationError, got", err)
+	}
+
+	expected := map[string]string{
+		"places[0]":      "negative tokens",
+		"places[1]":      "duplicate place id",
+		"transitions[0]": `required variable "comment" is not declared`,
+		"transitions[1]": "duplicate transition id",
+		"transitions[2]": "subprocess transition without process name",
+		"transitions[3]": "unknown transition type",
+		"arcs[0]":        `destination "nowhere" is not a transition`,
+		"arcs[1]":        "negative weight",
+		"arcs[2]":        "unknown arc type",
+		"arcs[3]":        "condition",
+	}
+	for path, reason := range expected {
+		found := false
+		for _, issue := range report.Issues {
+			if issue.Path == path && strings.Contains(issue.Reason, reason) {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("missing issue", path, reason, "in", report.Issues)
+		}
+	}
+	if len(report.Issues) != len(expected) {
+		t.Error("should report", len(expected), "issues, got", report.Issues)
+	}
+	if arc, ok := report.Issues[len(report.Issues)-1].Element.(bpnet.Arc); !ok || arc.Destination != "sub" {
+		t.Error("issue should carry the yaml element", report.Issues[len(report.Issues)-1].Element)
+	}
+}
+
+func TestBuildProcessFromYaml_Valid(t *testing.T) {
+	for _, file := range []string{"test/looper.yaml", "test/msg-sys.yaml", "test/subprocess.yaml"} {
+		b, _ := os.ReadFile(file)
+		var yamlstruct bpnet.ImportNet
+		if err := yaml.Unmarshal(b, &yamlstruct); err != nil {
+			t.Fatal(err)
+		}
+		process, err := bpnet.BuildProcessFromYaml(yamlstruct)
+		if err != nil {
+			t.Error(file, "should be valid, got", err)
+		}
+		if process.Name != yamlstruct.Title {
+			t.Error(file, "should build the process")
+		}
+	}
+}
+
+func TestValidateImportNet_ErrorArc(t *testing.T) {
+	definition := `
+title: errors
+transitions:
+  - id: approve
+    type: user
+  - id: call
+    type: system
+places:
+  - id: start
+  - id: failed
+arcs:
+  - {sourceId: approve, destinationId: failed, type: error}
+  - {sourceId: call, destinationId: failed, type: error}
+`
+	var yamlstruct bpnet.ImportNet
+	if err := yaml.Unmarshal([]byte(definition), &yamlstruct); err != nil {
+		t.Fatal(err)
+	}
+	report := bpnet.ValidateImportNet(yamlstruct)
+	if report.Len() != 1 || report.Issues[0].Path != "arcs[0]" || !strings.Contains(report.Issues[0].Reason, "not a system transition") {
+		t.Error("error arc should start at a system transition, got", report.Issues)
+	}
+}
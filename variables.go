@@ -0,0 +1,157 @@
+package bpnet
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// canonical variable types, an empty type accepts any value
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeTime   = "time"
+	TypeList   = "list"
+	TypeObject = "object"
+)
+
+// TypeError lists the variables with values which do not match the declared type
+type TypeError struct {
+	Fields []string
+	error
+}
+
+func (e *TypeError) Len() int {
+	return len(e.Fields)
+}
+
+// maps the type names allowed in the yaml to the canonical type, unknown types are returned as is
+func canonicalType(name string) string {
+	switch strings.ToLower(name) {
+	case "string", "text":
+		return TypeString
+	case "int", "integer":
+		return TypeInt
+	case "float", "number", "double":
+		return TypeFloat
+	case "bool", "boolean":
+		return TypeBool
+	case "date", "time", "datetime", "timestamp":
+		return TypeTime
+	case "list", "array":
+		return TypeList
+	case "object", "map":
+		return TypeObject
+	}
+	return name
+}
+
+// checks if a type name is known, an empty type is allowed
+func knownType(name string) bool {
+	switch canonicalType(name) {
+	case "", TypeString, TypeInt, TypeFloat, TypeBool, TypeTime, TypeList, TypeObject:
+		return true
+	}
+	return false
+}
+
+// coerces a value to the declared type, e.g. float64 from json to int.
+// nil values and untyped variables are passed through.
+func coerceValue(typeName string, value interface{}) (interface{}, bool) {
+	if value == nil {
+		return nil, true
+	}
+	switch canonicalType(typeName) {
+	case TypeString:
+		s, ok := value.(string)
+		return s, ok
+	case TypeInt:
+		return coerceInt(value)
+	case TypeFloat:
+		return coerceFloat(value)
+	case TypeBool:
+		b, ok := value.(bool)
+		return b, ok
+	case TypeTime:
+		switch v := value.(type) {
+		case time.Time:
+			return v, true
+		case string:
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				return t, true
+			}
+			if t, err := time.Parse("2006-01-02", v); err == nil {
+				return t, true
+			}
+		}
+		return nil, false
+	case TypeList:
+		kind := reflect.TypeOf(value).Kind()
+		return value, kind == reflect.Slice || kind == reflect.Array
+	case TypeObject:
+		kind := reflect.TypeOf(value).Kind()
+		return value, kind == reflect.Map || kind == reflect.Struct
+	}
+	return value, true
+}
+
+func coerceInt(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeOf(0)).Int()), true
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err == nil
+	}
+	f, ok := coerceFloat(value)
+	if !ok || f.(float64) != math.Trunc(f.(float64)) {
+		return nil, false
+	}
+	return int(f.(float64)), true
+}
+
+func coerceFloat(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int, int8, int16, int32, int64:
+		return float64(reflect.ValueOf(v).Int()), true
+	case uint, uint8, uint16, uint32, uint64:
+		return float64(reflect.ValueOf(v).Uint()), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return nil, false
+}
+
+// coerces the declared variables of data, returns a TypeError for mismatches
+func (flow *Flow) coerceData(data map[string]interface{}) (map[string]interface{}, error) {
+	coerced := make(map[string]interface{})
+	var typeError TypeError
+	for _, v := range flow.Process.Variables {
+		val, ok := data[v.ID]
+		if !ok {
+			continue
+		}
+		if c, ok := coerceValue(v.Type, val); ok {
+			coerced[v.ID] = c
+		} else {
+			typeError.error = errors.New("variables do not match the declared type")
+			typeError.Fields = append(typeError.Fields, v.ID)
+		}
+	}
+	if typeError.Len() > 0 {
+		return nil, typeError
+	}
+	return coerced, nil
+}
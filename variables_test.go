@@ -0,0 +1,82 @@
+package bpnet_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/veith/bpnet"
+)
+
+// test/typed.yaml declares a variable of each type and an untyped one, free
+
+func TestVariables_CoerceJSON(t *testing.T) {
+	var data map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"counts": 3, "price": 2, "name": "x", "active": true, "due": "2023-03-15T10:00:00Z",
+		"tags": ["a"], "address": {"city": "Bern"}, "free": 1.5
+	}`), &data)
+
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(buildfile(t, "test/typed.yaml"), "veith")
+	if err := f.Start(data); err != nil {
+		t.Fatal("start should accept the json data, got", err)
+	}
+	vars := f.ReadData()
+	if vars["counts"] != 3 {
+		t.Errorf("counts should be coerced to int, is %T", vars["counts"])
+	}
+	if vars["price"] != 2.0 {
+		t.Errorf("price should be a float, is %T", vars["price"])
+	}
+	if due, ok := vars["due"].(time.Time); !ok || due.Year() != 2023 {
+		t.Errorf("due should be parsed to time.Time, is %T", vars["due"])
+	}
+	if vars["free"] != 1.5 {
+		t.Error("untyped variables should pass through, is", vars["free"])
+	}
+}
+
+func TestVariables_TypeError(t *testing.T) {
+	process := buildfile(t, "test/typed.yaml", func(net *bpnet.ImportNet) {
+		net.Transition[6].TransitionType = "auto"
+	})
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(process, "veith")
+	if err := f.Start(map[string]interface{}{"counts": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := f.Fire(0, map[string]interface{}{
+		"counts":  1.5,
+		"name":    12,
+		"active":  "yes",
+		"due":     "tomorrow",
+		"tags":    "a,b",
+		"address": 3,
+		"price":   4,
+		"free":    "anything",
+	})
+	typeError, ok := err.(bpnet.TypeError)
+	if !ok {
+		t.Fatal("should return a TypeError, got", err)
+	}
+	if typeError.Len() != 6 {
+		t.Error("should report 6 mismatching fields, got", typeError.Fields)
+	}
+	if f.ReadData()["counts"] != 1 || f.ReadData()["price"] != nil {
+		t.Error("no data should be taken over on type errors", f.ReadData())
+	}
+	if len(f.AvailableUserTransitions) == 0 {
+		t.Error("transition should not fire on type errors")
+	}
+}
+
+func TestVariables_ReadDataCopy(t *testing.T) {
+	f := bpnet.NewEngine(&bpnet.Handler{}).CreateFlow(buildfile(t, "test/typed.yaml"), "veith")
+	f.Start(map[string]interface{}{"counts": 1})
+
+	vars := f.ReadData()
+	vars["counts"] = 2
+	if f.Net.Variables["counts"] != 1 {
+		t.Error("ReadData should return a copy of the variables", f.Net.Variables)
+	}
+}
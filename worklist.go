@@ -0,0 +1,254 @@
+package bpnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/oklog/ulid"
+)
+
+// Actor is a user, the groups the user belongs to and the roles of the user
+type Actor struct {
+	ID     string   `json:"id"`
+	Groups []string `json:"groups,omitempty"` // for the candidate groups of tasks
+	Roles  []string `json:"roles,omitempty"`  // for the Policy
+}
+
+type actorKey struct{}
+
+// WithActor passes the acting user to StartContext, FireContext and the worklist
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor of the context
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok
+}
+
+// id of the actor of the context, fallback for calls without actor
+func actorID(ctx context.Context, fallback string) string {
+	if actor, ok := ActorFrom(ctx); ok && actor.ID != "" {
+		return actor.ID
+	}
+	return fallback
+}
+
+// Task is an enabled USER transition of a flow. The candidates come from the
+// transition details "users" and "groups", a task without candidates is open for everyone.
+type Task struct {
+	FlowID          ulid.ULID `json:"flow"`
+	ProcessName     string    `json:"procname"`
+	Transition      int       `json:"transition"`
+	TransitionID    string    `json:"transition_id"`
+	CandidateUsers  []string  `json:"candidate_users,omitempty"`
+	CandidateGroups []string  `json:"candidate_groups,omitempty"`
+	Assignee        string    `json:"assignee,omitempty"`
+}
+
+// errors of the worklist, wrapped in a TaskError
+var (
+	ErrTaskNotEnabled = errors.New("task is not enabled")
+	ErrTaskClaimed    = errors.New("task is claimed by another user")
+	ErrNotCandidate   = errors.New("user is no candidate of the task")
+	ErrNotAssignee    = errors.New("user is not the assignee of the task")
+)
+
+// TaskError is returned when a worklist operation or a fire on a claimed task is refused
+type TaskError struct {
+	FlowID     ulid.ULID
+	Transition int
+	User       string
+	Err        error
+}
+
+func (e TaskError) Error() string {
+	return fmt.Sprintf("task %d of flow %s, user %q: %v", e.Transition, e.FlowID, e.User, e.Err)
+}
+
+func (e TaskError) Unwrap() error {
+	return e.Err
+}
+
+// Tasks lists the enabled USER transitions of the flow, cancelled and suspended flows have none
+func (f *Flow) Tasks() []Task {
+	var tasks []Task
+	f.View(func(f *Flow) {
+		if f.checkActive() != nil || f.Suspended {
+			return
+		}
+		for _, transition := range f.Net.EnabledTransitions {
+			if f.Process.TransitionTypes[transition] == int(USER) {
+				tasks = append(tasks, f.task(transition))
+			}
+		}
+	})
+	return tasks
+}
+
+func (f *Flow) task(transition int) Task {
+	task := Task{FlowID: f.ID, ProcessName: f.ProcessName, Transition: transition, TransitionID: f.transitionID(transition), Assignee: f.Assignees[transition]}
+	if transition < len(f.Process.Transitions) {
+		details := f.Process.Transitions[transition].Details
+		task.CandidateUsers = detailStrings(details["users"])
+		task.CandidateGroups = detailStrings(details["groups"])
+	}
+	return task
+}
+
+// Candidate tells if the actor may claim the task
+func (t Task) Candidate(actor Actor) bool {
+	if len(t.CandidateUsers) == 0 && len(t.CandidateGroups) == 0 {
+		return true
+	}
+	for _, user := range t.CandidateUsers {
+		if user == actor.ID {
+			return true
+		}
+	}
+	for _, group := range t.CandidateGroups {
+		for _, member := range actor.Groups {
+			if group == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Claim assigns an open task to the actor, if the actor is a candidate
+func (f *Flow) Claim(transitionIndex int, actor Actor) error {
+	return f.changeAssignee(transitionIndex, actor.ID, actor.ID, EventTaskClaimed, func(task Task) error {
+		if task.Assignee != "" && task.Assignee != actor.ID {
+			return ErrTaskClaimed
+		}
+		if !task.Candidate(actor) {
+			return ErrNotCandidate
+		}
+		return nil
+	})
+}
+
+// Unclaim puts a task claimed by the actor back to the candidates
+func (f *Flow) Unclaim(transitionIndex int, actor Actor) error {
+	return f.changeAssignee(transitionIndex, actor.ID, "", EventTaskUnclaimed, func(task Task) error {
+		if task.Assignee != actor.ID {
+			return ErrNotAssignee
+		}
+		return nil
+	})
+}
+
+// Assign sets the assignee of a task without checking the candidates, e.g. by an administrator or a system
+func (f *Flow) Assign(transitionIndex int, user string) error {
+	return f.changeAssignee(transitionIndex, "", user, EventTaskAssigned, func(task Task) error {
+		return nil
+	})
+}
+
+// Delegate hands a task claimed by the actor over to another user
+func (f *Flow) Delegate(transitionIndex int, actor Actor, user string) error {
+	return f.changeAssignee(transitionIndex, actor.ID, user, EventTaskAssigned, func(task Task) error {
+		if task.Assignee != actor.ID {
+			return ErrNotAssignee
+		}
+		return nil
+	})
+}
+
+// changes the assignee of a task, by is the acting user
+func (f *Flow) changeAssignee(transitionIndex int, by string, assignee string, eventType EventType, allowed func(task Task) error) error {
+	_, unlock := f.lock(context.Background())
+	defer unlock()
+	if err := f.checkActive(); err != nil {
+		return TaskError{FlowID: f.ID, Transition: transitionIndex, User: by, Err: err}
+	}
+	if f.Suspended {
+		return TaskError{FlowID: f.ID, Transition: transitionIndex, User: by, Err: ErrFlowSuspended}
+	}
+	if !containsInt(f.Net.EnabledTransitions, transitionIndex) || f.Process.TransitionTypes[transitionIndex] != int(USER) {
+		return TaskError{FlowID: f.ID, Transition: transitionIndex, User: by, Err: ErrTaskNotEnabled}
+	}
+	task := f.task(transitionIndex)
+	if err := allowed(task); err != nil {
+		return TaskError{FlowID: f.ID, Transition: transitionIndex, User: by, Err: err}
+	}
+	if f.Assignees == nil {
+		f.Assignees = make(map[int]string)
+	}
+	if assignee == "" {
+		delete(f.Assignees, transitionIndex)
+	} else {
+		f.Assignees[transitionIndex] = assignee
+	}
+	f.record(Event{Type: eventType, TransitionID: task.TransitionID, Actor: by, Assignee: assignee})
+	return nil
+}
+
+// a claimed task is fired by its assignee only
+func (f *Flow) checkAssignee(ctx context.Context, transitionIndex int) error {
+	assignee, claimed := f.Assignees[transitionIndex]
+	if !claimed {
+		return nil
+	}
+	actor, _ := ActorFrom(ctx)
+	if actor.ID != assignee {
+		return TaskError{FlowID: f.ID, Transition: transitionIndex, User: actor.ID, Err: ErrNotAssignee}
+	}
+	return nil
+}
+
+// assignees of tasks which are no longer enabled are dropped
+func (f *Flow) pruneAssignees() {
+	for transition := range f.Assignees {
+		if !containsInt(f.Net.EnabledTransitions, transition) {
+			delete(f.Assignees, transition)
+		}
+	}
+}
+
+// Tasks returns the open tasks of the actor over all running flows of the engine:
+// the tasks assigned to the actor and the unclaimed tasks the actor is a candidate for
+func (e *Engine) Tasks(actor Actor) []Task {
+	var tasks []Task
+	for _, flow := range e.Flows() {
+		for _, task := range flow.Tasks() {
+			if task.Assignee == actor.ID || task.Assignee == "" && task.Candidate(actor) {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].FlowID != tasks[j].FlowID {
+			return tasks[i].FlowID.Compare(tasks[j].FlowID) < 0
+		}
+		return tasks[i].Transition < tasks[j].Transition
+	})
+	return tasks
+}
+
+// reads a list of strings from transition details, yaml gives []interface{}, a string may be comma separated
+func detailStrings(value interface{}) []string {
+	var list []string
+	switch v := value.(type) {
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	case []string:
+		list = append(list, v...)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
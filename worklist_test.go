@@ -0,0 +1,91 @@
+package bpnet_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/veith/bpnet"
+)
+
+// test/worklist.yaml: start -> split (auto) -> p1 + p2, p1 -> review (user, group reviewers) -> p3, p2 -> sign (user anna) -> p4
+
+var (
+	anna = bpnet.Actor{ID: "anna"}
+	ben  = bpnet.Actor{ID: "ben", Groups: []string{"reviewers"}}
+	carl = bpnet.Actor{ID: "carl", Groups: []string{"reviewers"}}
+)
+
+func TestWorklist_Tasks(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	f := e.CreateFlow(buildfile(t, "test/worklist.yaml"), "veith")
+	f.Start(nil)
+
+	if tasks := e.Tasks(anna); len(tasks) != 1 || tasks[0].TransitionID != "sign" {
+		t.Error("anna should see the sign task only", tasks)
+	}
+	if tasks := e.Tasks(ben); len(tasks) != 1 || tasks[0].TransitionID != "review" {
+		t.Error("ben should see the review task of his group", tasks)
+	}
+
+	if err := f.Claim(1, ben); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := e.Tasks(carl); len(tasks) != 0 {
+		t.Error("claimed task should leave the worklist of the other candidates", tasks)
+	}
+	if tasks := e.Tasks(ben); len(tasks) != 1 || tasks[0].Assignee != "ben" {
+		t.Error("claimed task should show the assignee", tasks)
+	}
+}
+
+func TestWorklist_ClaimRules(t *testing.T) {
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	f := e.CreateFlow(buildfile(t, "test/worklist.yaml"), "veith")
+	f.Start(nil)
+
+	if err := f.Claim(2, ben); !errors.Is(err, bpnet.ErrNotCandidate) {
+		t.Error("only candidates should claim, got", err)
+	}
+	f.Claim(1, ben)
+	if err := f.Claim(1, carl); !errors.Is(err, bpnet.ErrTaskClaimed) {
+		t.Error("claimed task should not be claimed again, got", err)
+	}
+	if err := f.Unclaim(1, carl); !errors.Is(err, bpnet.ErrNotAssignee) {
+		t.Error("only the assignee should unclaim, got", err)
+	}
+	if err := f.Delegate(1, ben, "carl"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Unclaim(1, carl); err != nil {
+		t.Error("delegated task should belong to carl, got", err)
+	}
+	if err := f.Assign(0, "anna"); !errors.Is(err, bpnet.ErrTaskNotEnabled) {
+		t.Error("only enabled user tasks should be assigned, got", err)
+	}
+}
+
+func TestWorklist_FireClaimed(t *testing.T) {
+	sink := &bpnet.MemorySink{}
+	e := bpnet.NewEngine(&bpnet.Handler{})
+	e.History = sink
+	f := e.CreateFlow(buildfile(t, "test/worklist.yaml"), "veith")
+	f.Start(nil)
+	f.Assign(1, "ben")
+
+	if err := f.Fire(1, nil); !errors.Is(err, bpnet.ErrNotAssignee) {
+		t.Error("claimed task should refuse a fire without actor, got", err)
+	}
+	if err := f.FireContext(bpnet.WithActor(context.Background(), carl), 1, nil); !errors.Is(err, bpnet.ErrNotAssignee) {
+		t.Error("claimed task should refuse other users, got", err)
+	}
+	if err := f.FireContext(bpnet.WithActor(context.Background(), ben), 1, nil); err != nil {
+		t.Error("assignee should fire, got", err)
+	}
+	if len(f.Assignees) != 0 {
+		t.Error("fired task should drop its assignee", f.Assignees)
+	}
+	if err := bpnet.VerifyHistory(&f, sink.Events(f.ID)); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,6 +1,9 @@
 package bpnet
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"strings"
 )
 
@@ -12,12 +15,23 @@ func MakeProcessFromYaml(yamlstruct ImportNet) Process {
 
 	var targetNetwork Process
 	targetNetwork.Name = yamlstruct.Title
+	targetNetwork.Version = yamlstruct.Version
+	if targetNetwork.Version == "" {
+		targetNetwork.Version = contentVersion(yamlstruct)
+	}
 
 	// places
 	places := make(map[string]int)
 	for index, place := range yamlstruct.Place {
 		// inital state
 		targetNetwork.InitialState = append(targetNetwork.InitialState, place.Tokens)
+		targetNetwork.Places = append(targetNetwork.Places, place.ID)
+		if place.Choice != "" {
+			if targetNetwork.ChoicePolicies == nil {
+				targetNetwork.ChoicePolicies = make([]string, len(yamlstruct.Place))
+			}
+			targetNetwork.ChoicePolicies[index] = place.Choice
+		}
 		// build map
 		places[place.ID] = index
 	}
@@ -30,18 +44,7 @@ func MakeProcessFromYaml(yamlstruct ImportNet) Process {
 		targetNetwork.Transitions = append(targetNetwork.Transitions, transition)
 
 		// ttype ist eine bitmatrix
-		var ttype TaskType
-		ttype = 0
-		ttype = ttypeCheck(transition.TransitionType, "auto", ttype, AUTO)
-		ttype = ttypeCheck(transition.TransitionType, "form", ttype, USER)
-		ttype = ttypeCheck(transition.TransitionType, "user", ttype, USER)
-		ttype = ttypeCheck(transition.TransitionType, "message", ttype, MESSAGE)
-		ttype = ttypeCheck(transition.TransitionType, "timed", ttype, TIMED)
-		ttype = ttypeCheck(transition.TransitionType, "subprocess", ttype, SUBPROCESS)
-		ttype = ttypeCheck(transition.TransitionType, "system", ttype, SYSTEM)
-		ttype = ttypeCheck(transition.TransitionType, "call", ttype, SYSTEM)
-		ttype = ttypeCheck(transition.TransitionType, "ai", ttype, SYSTEM)
-		ttype = ttypeCheck(transition.TransitionType, "api", ttype, SYSTEM)
+		ttype := transitionType(transition.TransitionType)
 		targetNetwork.TransitionTypes = append(targetNetwork.TransitionTypes, int(ttype))
 
 	}
@@ -49,11 +52,16 @@ func MakeProcessFromYaml(yamlstruct ImportNet) Process {
 	// Variables
 	targetNetwork.Variables = yamlstruct.Variables
 	targetNetwork.StartVariables = yamlstruct.StartVariables
+	targetNetwork.SingletonIdentifiers = yamlstruct.SingletonIdentifiers
 
 	// create null Matrix
 	inputMatrix := make([][]int, len(transitions))
 	outputMatrix := make([][]int, len(transitions))
 	conditionMatrix := make([][]string, len(transitions))
+	var errorMatrix [][]int
+	var priorityMatrix [][]int
+	var defaultMatrix [][]bool
+	var arcConditions [][]string
 	for i := 0; i < len(transitions); i++ {
 		innerLen := len(places)
 		inputMatrix[i] = make([]int, innerLen)
@@ -72,6 +80,33 @@ func MakeProcessFromYaml(yamlstruct ImportNet) Process {
 			inputMatrix[transitions[arc.Destination]][places[arc.Source]] = max(1, arc.Weight)
 			if len(arc.Condition) > 0 {
 				conditionMatrix[transitions[arc.Destination]] = append(conditionMatrix[transitions[arc.Destination]], arc.Condition)
+				// die condition matrix kennt die stelle nicht mehr
+				if arcConditions == nil {
+					arcConditions = make([][]string, len(transitions))
+				}
+				if arcConditions[transitions[arc.Destination]] == nil {
+					arcConditions[transitions[arc.Destination]] = make([]string, len(places))
+				}
+				arcConditions[transitions[arc.Destination]][places[arc.Source]] = arc.Condition
+			}
+			// exklusive entscheidung
+			if arc.Priority > 0 {
+				if priorityMatrix == nil {
+					priorityMatrix = make([][]int, len(transitions))
+				}
+				if priorityMatrix[transitions[arc.Destination]] == nil {
+					priorityMatrix[transitions[arc.Destination]] = make([]int, len(places))
+				}
+				priorityMatrix[transitions[arc.Destination]][places[arc.Source]] = arc.Priority
+			}
+			if arc.Default {
+				if defaultMatrix == nil {
+					defaultMatrix = make([][]bool, len(transitions))
+				}
+				if defaultMatrix[transitions[arc.Destination]] == nil {
+					defaultMatrix[transitions[arc.Destination]] = make([]bool, len(places))
+				}
+				defaultMatrix[transitions[arc.Destination]][places[arc.Source]] = true
 			}
 
 		}
@@ -79,14 +114,91 @@ func MakeProcessFromYaml(yamlstruct ImportNet) Process {
 		if (arc.Type == "tp") {
 			outputMatrix[transitions[arc.Source]][places[arc.Destination]] = max(arc.Weight,1)
 		}
+
+		// fehlerpfad eines systemtasks, siehe FailSystemTask
+		if (arc.Type == "error") {
+			if errorMatrix == nil {
+				errorMatrix = make([][]int, len(transitions))
+			}
+			if errorMatrix[transitions[arc.Source]] == nil {
+				errorMatrix[transitions[arc.Source]] = make([]int, len(places))
+			}
+			errorMatrix[transitions[arc.Source]][places[arc.Destination]] = max(arc.Weight,1)
+		}
+	}
+	// bedingte ausgänge, die reihenfolge der arcs gilt für xor
+	var outputArcs [][]OutputArc
+	for index, transition := range yamlstruct.Transition {
+		conditional := transition.Details["split"] == SplitXOR
+		for _, arc := range yamlstruct.Arc {
+			if arc.Type == "tp" && arc.Source == transition.ID && arc.Condition != "" {
+				conditional = true
+			}
+		}
+		if !conditional {
+			continue
+		}
+		if outputArcs == nil {
+			outputArcs = make([][]OutputArc, len(transitions))
+		}
+		outputArcs[index] = []OutputArc{}
+		for _, arc := range yamlstruct.Arc {
+			if arc.Type == "tp" && arc.Source == transition.ID {
+				outputArcs[index] = append(outputArcs[index], OutputArc{Place: places[arc.Destination], Weight: max(arc.Weight,1), Condition: arc.Condition})
+			}
+		}
 	}
+
 	targetNetwork.InputMatrix = inputMatrix
 	targetNetwork.OutputMatrix = outputMatrix
 	targetNetwork.ConditionMatrix = conditionMatrix
+	targetNetwork.ErrorMatrix = errorMatrix
+	targetNetwork.PriorityMatrix = priorityMatrix
+	targetNetwork.DefaultMatrix = defaultMatrix
+	targetNetwork.OutputArcs = outputArcs
+	targetNetwork.ArcConditions = arcConditions
 
 	return targetNetwork
 
 }
+
+// Erstellt aus einem ImportNet ein Process Struct, ein ungültiges ImportNet wird mit einem ValidationError abgelehnt
+func BuildProcessFromYaml(yamlstruct ImportNet) (Process, error) {
+	if report := ValidateImportNet(yamlstruct); report.Len() > 0 {
+		return Process{}, report
+	}
+	return MakeProcessFromYaml(yamlstruct), nil
+}
+
+// version aus dem inhalt der definition, gleiche definitionen ergeben die gleiche version
+func contentVersion(yamlstruct ImportNet) string {
+	b, err := json.Marshal(yamlstruct)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:6])
+}
+
+// bestimmt den TaskType aus dem type der transition
+func transitionType(transitionType string) TaskType {
+	var ttype TaskType
+	ttype = 0
+	ttype = ttypeCheck(transitionType, "auto", ttype, AUTO)
+	ttype = ttypeCheck(transitionType, "form", ttype, USER)
+	ttype = ttypeCheck(transitionType, "user", ttype, USER)
+	ttype = ttypeCheck(transitionType, "message", ttype, MESSAGE)
+	ttype = ttypeCheck(transitionType, "timed", ttype, TIMED)
+	ttype = ttypeCheck(transitionType, "subprocess", ttype, SUBPROCESS)
+	ttype = ttypeCheck(transitionType, "system", ttype, SYSTEM)
+	ttype = ttypeCheck(transitionType, "call", ttype, SYSTEM)
+	ttype = ttypeCheck(transitionType, "ai", ttype, SYSTEM)
+	ttype = ttypeCheck(transitionType, "api", ttype, SYSTEM)
+	ttype = ttypeCheck(transitionType, "receive", ttype, RECEIVE)
+	ttype = ttypeCheck(transitionType, "signal", ttype, SIGNAL)
+	return ttype
+}
+
 func ttypeCheck(transitionType string, substr string, ttype TaskType, assign TaskType) TaskType {
 	if strings.Contains(strings.ToLower(transitionType), substr) {
 		ttype = ttype  | assign
@@ -97,12 +209,14 @@ func ttypeCheck(transitionType string, substr string, ttype TaskType, assign Tas
 
 // structs werden gehoistet wie es aussieht
 type ImportNet struct {
-	Title          string       `json:"title"` // Affects YAML field names too.
-	Transition     []Transition `json:"transitions"`
-	Variables      []Variable   `json:"variables"`
-	StartVariables []string     `json:"startvariables"`
-	Place          []Place      `json:"places"`
-	Arc            []Arc        `json:"arcs"`
+	Title                string       `json:"title"`   // Affects YAML field names too.
+	Version              string       `json:"version"` // empty for a version from the content
+	Transition           []Transition `json:"transitions"`
+	Variables            []Variable   `json:"variables"`
+	StartVariables       []string     `json:"startvariables"`
+	SingletonIdentifiers []string     `json:"singletonidentifiers"`
+	Place                []Place      `json:"places"`
+	Arc                  []Arc        `json:"arcs"`
 }
 
 type Transition struct {
@@ -121,6 +235,7 @@ type Place struct {
 	ID     string `json:"id"`
 	Label  string `json:"label"`
 	Tokens int    `json:"tokens"`
+	Choice string `json:"choice,omitempty"` // first or strict, see ChoiceStrict
 }
 
 type Arc struct {
@@ -129,4 +244,6 @@ type Arc struct {
 	Condition   string `json:"condition"`
 	Type        string `json:"type"`
 	Weight      int    `json:"weight"`
+	Priority    int    `json:"priority,omitempty"` // pt arcs of a choice, 1 is taken first
+	Default     bool   `json:"default,omitempty"`  // pt arc taken when no other branch of the choice qualifies
 }
@@ -75,9 +75,11 @@ func TestConditions(t *testing.T) {
 
 	time.Sleep(120 * time.Millisecond)
 
-	if flow.Net.State[0] != 1 {
-		t.Error("process muss aufgrund bedingungen hier aufhören")
-	}
+	flow.View(func(flow *bpnet.Flow) {
+		if flow.Net.State[0] != 1 {
+			t.Error("process muss aufgrund bedingungen hier aufhören")
+		}
+	})
 }
 
 func TestMakeProcessFromYaml(t *testing.T) {
@@ -113,7 +115,10 @@ func OnSystemTask(flow *bpnet.Flow, tokenID int, transitionIndex int) bool {
 	if flow.Process.Transitions[transitionIndex].Details["target"] == "adder" {
 		fmt.Println(flow.Net.Variables["counts"])
 		time.AfterFunc(100*time.Millisecond, func() {
-			d := map[string]interface{}{"counts": flow.Net.Variables["counts"].(int) + 1}
+			var d map[string]interface{}
+			flow.View(func(flow *bpnet.Flow) {
+				d = map[string]interface{}{"counts": flow.Net.Variables["counts"].(int) + 1}
+			})
 			flow.FireSystemTask(tokenID, d)
 		})
 
@@ -143,3 +148,24 @@ func readfile(filename string) bpnet.Process {
 	return process
 
 }
+
+// builds a valid net from the file, edits change the net before it is built
+func buildfile(t *testing.T, filename string, edits ...func(net *bpnet.ImportNet)) bpnet.Process {
+	t.Helper()
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var net bpnet.ImportNet
+	if err := yaml.Unmarshal(b, &net); err != nil {
+		t.Fatal(err)
+	}
+	for _, edit := range edits {
+		edit(&net)
+	}
+	process, err := bpnet.BuildProcessFromYaml(net)
+	if err != nil {
+		t.Fatal(filename, err)
+	}
+	return process
+}